@@ -0,0 +1,207 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// activeStatuses are the statuses of actions that have not finished yet:
+// waiting for a lease, executing, or backing off before a retry.
+var activeStatuses = []string{"waiting", "running", "retrying"}
+
+func isActive(status string) bool {
+	for _, s := range activeStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+type actionInfo struct {
+	ActionID        string          `json:"action_id"`
+	Kind            string          `json:"kind"`
+	Status          string          `json:"status"`
+	DesiredReplicas int             `json:"desired_replicas,omitempty"`
+	Target          string          `json:"target,omitempty"`
+	IncidentID      string          `json:"incident_id,omitempty"`
+	Attempts        int             `json:"attempts"`
+	LockHolder      string          `json:"lock_holder,omitempty"`
+	Error           string          `json:"error,omitempty"`
+	CancelRequested bool            `json:"cancel_requested"`
+	CreatedAt       string          `json:"created_at"`
+	UpdatedAt       string          `json:"updated_at"`
+	History         []historyRecord `json:"history,omitempty"`
+}
+
+type historyRecord struct {
+	Status    string `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+const actionColumns = `e.action_id, e.kind, e.status, e.desired_replicas, COALESCE(e.target,''), COALESCE(e.incident_id,''),
+	e.attempts, COALESCE(e.lock_holder,''), COALESCE(e.error,''), c.action_id IS NOT NULL, e.created_at, e.updated_at`
+
+func scanAction(sc interface{ Scan(...any) error }) (actionInfo, error) {
+	var a actionInfo
+	err := sc.Scan(&a.ActionID, &a.Kind, &a.Status, &a.DesiredReplicas, &a.Target, &a.IncidentID,
+		&a.Attempts, &a.LockHolder, &a.Error, &a.CancelRequested, &a.CreatedAt, &a.UpdatedAt)
+	return a, err
+}
+
+// handleActions serves GET /actions?status=running,waiting&kind=scale_docker&limit=100.
+// Without status only unfinished actions are listed.
+func (r *Runner) handleActions(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := req.URL.Query()
+	statuses := activeStatuses
+	if v := strings.TrimSpace(q.Get("status")); v != "" {
+		statuses = strings.Split(v, ",")
+	}
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			http.Error(w, "limit must be 1..1000", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	var args []any
+	ph := make([]string, len(statuses))
+	for i, st := range statuses {
+		args = append(args, strings.TrimSpace(st))
+		ph[i] = "$" + strconv.Itoa(len(args))
+	}
+	where := "e.status IN (" + strings.Join(ph, ",") + ")"
+	if kind := strings.TrimSpace(q.Get("kind")); kind != "" {
+		args = append(args, kind)
+		where += " AND e.kind = $" + strconv.Itoa(len(args))
+	}
+	args = append(args, limit)
+	rows, err := r.db.QueryContext(req.Context(), `SELECT `+actionColumns+`
+		FROM action_exec e LEFT JOIN action_cancels c ON c.action_id = e.action_id
+		WHERE `+where+` ORDER BY e.id DESC LIMIT $`+strconv.Itoa(len(args)), args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	items := []actionInfo{}
+	for rows.Next() {
+		a, err := scanAction(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		items = append(items, a)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// handleAction serves GET /actions/{id} and POST /actions/{id}/cancel.
+func (r *Runner) handleAction(w http.ResponseWriter, req *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(req.URL.Path, "/actions/"), "/")
+	parts := strings.Split(rest, "/")
+	if parts[0] == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+	switch {
+	case len(parts) == 1:
+		if req.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		r.getAction(w, req, parts[0])
+	case len(parts) == 2 && parts[1] == "cancel":
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		r.cancelAction(w, req, parts[0])
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (r *Runner) getAction(w http.ResponseWriter, req *http.Request, id string) {
+	a, err := scanAction(r.db.QueryRowContext(req.Context(), `SELECT `+actionColumns+`
+		FROM action_exec e LEFT JOIN action_cancels c ON c.action_id = e.action_id
+		WHERE e.action_id=$1`, id))
+	if err == sql.ErrNoRows {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rows, err := r.db.QueryContext(req.Context(), `SELECT status, COALESCE(detail,''), created_at FROM action_history WHERE action_id=$1 ORDER BY id`, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var h historyRecord
+		if err := rows.Scan(&h.Status, &h.Detail, &h.CreatedAt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		a.History = append(a.History, h)
+	}
+	writeJSON(w, http.StatusOK, a)
+}
+
+// cancelRequest is the optional body of POST /actions/{id}/cancel.
+type cancelRequest struct {
+	Reason      string `json:"reason,omitempty"`
+	RequestedBy string `json:"requested_by,omitempty"`
+}
+
+// cancelAction accepts a cancellation for an unfinished action. Actions no
+// runner has received answer 404, finished ones 409.
+func (r *Runner) cancelAction(w http.ResponseWriter, req *http.Request, id string) {
+	var body cancelRequest
+	dec := json.NewDecoder(req.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	status, err := r.actionStatus(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if status == "" {
+		http.Error(w, "action not found", http.StatusNotFound)
+		return
+	}
+	if !isActive(status) {
+		http.Error(w, "action already "+status, http.StatusConflict)
+		return
+	}
+	if err := r.requestCancel(req.Context(), id, strings.TrimSpace(body.Reason), strings.TrimSpace(body.RequestedBy)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]any{"action_id": id, "status": status, "cancel_requested": true})
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+
+	"github.com/ilya2309548/EventPulse/internal/consumer"
+	"github.com/ilya2309548/EventPulse/internal/events"
+	"github.com/ilya2309548/EventPulse/internal/storage"
+)
+
+// errCancelled is the cancellation cause of an action stopped on request.
+type errCancelled struct{ reason string }
+
+func (e errCancelled) Error() string { return "cancelled: " + e.reason }
+
+func isCancelled(err error) (string, bool) {
+	var c errCancelled
+	if errors.As(err, &c) {
+		return c.reason, true
+	}
+	return "", false
+}
+
+// migrateCancels creates the cancellation requests shared by all runners and
+// the per-action status history.
+func migrateCancels(db *sql.DB) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS action_cancels (
+			action_id TEXT PRIMARY KEY,
+			reason TEXT,
+			requested_by TEXT,
+			requested_at TEXT NOT NULL,
+			handled_at TEXT
+		)`,
+		`CREATE TABLE IF NOT EXISTS action_history (
+			id SERIAL PRIMARY KEY,
+			action_id TEXT NOT NULL,
+			status TEXT NOT NULL,
+			detail TEXT,
+			created_at TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_action_history_action ON action_history(action_id, id)`,
+	}
+	for _, s := range stmts {
+		if _, err := db.Exec(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendHistory(db storage.Querier, actionID, status, detail, now string) error {
+	_, err := db.Exec(`INSERT INTO action_history (action_id, status, detail, created_at) VALUES ($1,$2,NULLIF($3,''),$4)`,
+		actionID, status, detail, now)
+	return err
+}
+
+// track makes a running action cancellable by this runner.
+func (r *Runner) track(actionID string, cancel context.CancelCauseFunc) {
+	r.activeMu.Lock()
+	defer r.activeMu.Unlock()
+	r.active[actionID] = cancel
+}
+
+func (r *Runner) untrack(actionID string) {
+	r.activeMu.Lock()
+	defer r.activeMu.Unlock()
+	if cancel, ok := r.active[actionID]; ok {
+		cancel(nil)
+		delete(r.active, actionID)
+	}
+}
+
+// cancelLocal stops actionID if it runs on this runner.
+func (r *Runner) cancelLocal(actionID, reason string) bool {
+	r.activeMu.Lock()
+	defer r.activeMu.Unlock()
+	cancel, ok := r.active[actionID]
+	if ok {
+		cancel(errCancelled{reason: reason})
+	}
+	return ok
+}
+
+// requestCancel records a cancellation request. The runner executing the
+// action (this one or another) stops it within cancelPoll; an action that has
+// not started yet is cancelled as soon as a runner picks it up.
+func (r *Runner) requestCancel(ctx context.Context, actionID, reason, requestedBy string) error {
+	if reason == "" {
+		reason = "cancel requested"
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	if _, err := r.db.ExecContext(ctx, `INSERT INTO action_cancels (action_id, reason, requested_by, requested_at) VALUES ($1,$2,NULLIF($3,''),$4)
+		ON CONFLICT (action_id) DO NOTHING`, actionID, reason, requestedBy, now); err != nil {
+		return err
+	}
+	if r.cancelLocal(actionID, reason) {
+		log.Printf("action %s: cancel requested (%s)", actionID, reason)
+	}
+	return nil
+}
+
+// cancelRequested reports whether a cancellation was requested for actionID.
+func (r *Runner) cancelRequested(ctx context.Context, actionID string) (string, bool, error) {
+	var reason string
+	err := r.db.QueryRowContext(ctx, `SELECT COALESCE(reason,'') FROM action_cancels WHERE action_id=$1`, actionID).Scan(&reason)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return reason, true, nil
+}
+
+// watchCancels picks up cancellation requests made through other runners
+// and stops the matching actions running here.
+func (r *Runner) watchCancels(ctx context.Context) {
+	t := time.NewTicker(r.cancelPoll)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		r.activeMu.Lock()
+		n := len(r.active)
+		r.activeMu.Unlock()
+		if n == 0 {
+			continue
+		}
+		// Requests for actions that already finished have nothing left to stop
+		if _, err := r.db.ExecContext(ctx, `UPDATE action_cancels c SET handled_at=$1 WHERE c.handled_at IS NULL
+			AND EXISTS (SELECT 1 FROM action_history h WHERE h.action_id = c.action_id
+				AND h.status IN ('completed','failed','cancelled','superseded'))`,
+			time.Now().UTC().Format(time.RFC3339)); err != nil {
+			log.Printf("watch cancels: %v", err)
+		}
+		rows, err := r.db.QueryContext(ctx, `SELECT action_id, COALESCE(reason,'') FROM action_cancels WHERE handled_at IS NULL`)
+		if err != nil {
+			log.Printf("watch cancels: %v", err)
+			continue
+		}
+		for rows.Next() {
+			var id, reason string
+			if err := rows.Scan(&id, &reason); err == nil && r.cancelLocal(id, reason) {
+				log.Printf("action %s: cancel requested (%s)", id, reason)
+			}
+		}
+		rows.Close()
+	}
+}
+
+// processCancel handles one action.cancel message. Recording the request is
+// idempotent, so no inbox entry is needed.
+func (r *Runner) processCancel(ctx context.Context, msg kafka.Message) error {
+	env, err := events.Peek(msg.Value)
+	if err != nil {
+		return consumer.Permanent(err)
+	}
+	if env.Type != events.TypeActionCancel {
+		return nil
+	}
+	ev, err := events.DecodeActionCancel(msg.Value)
+	if err != nil {
+		return consumer.Permanent(err)
+	}
+	return r.requestCancel(ctx, ev.ActionID, ev.Reason, ev.RequestedBy)
+}
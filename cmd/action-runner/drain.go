@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ilya2309548/EventPulse/internal/containers"
+)
+
+// draining configures graceful scale-down.
+type draining struct {
+	period time.Duration // SCALE_DOWN_DRAIN: max wait for the replica to leave load balancing
+	grace  time.Duration // SCALE_DOWN_STOP_GRACE: SIGTERM to SIGKILL
+	path   string        // drain endpoint on the replica, on the readiness probe port
+}
+
+// drainAndRemove takes a replica out of service without dropping requests:
+//  1. POST /drain makes its /healthz fail; once Docker marks it unhealthy,
+//     Traefik stops routing new requests to it;
+//  2. waits for that, at most the drain period;
+//  3. stops it with SIGTERM (the app finishes in-flight requests) and a grace
+//     timeout, then removes it.
+func (r *Runner) drainAndRemove(ctx context.Context, id string) error {
+	c, err := r.docker.Inspect(ctx, id)
+	if containers.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if c.Running {
+		r.requestDrain(ctx, c)
+		r.waitDrained(ctx, c)
+		if err := r.docker.Stop(ctx, id, r.draining.grace); err != nil && !containers.IsNotFound(err) {
+			log.Printf("stop %s failed, forcing removal: %v", shortID(id), err)
+		}
+	}
+	return r.removeContainer(ctx, id)
+}
+
+func (r *Runner) requestDrain(ctx context.Context, c containers.Container) {
+	host := c.IPAddress
+	if host == "" {
+		host = c.Name
+	}
+	url := fmt.Sprintf("http://%s:%d%s", host, r.readiness.port, r.draining.path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return
+	}
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("drain %s: %v", shortID(c.ID), err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// waitDrained waits until the replica reports unhealthy (it is then out of
+// the Traefik rotation) or the drain period passes. Without a healthcheck
+// there is nothing to observe, so the full period is waited.
+func (r *Runner) waitDrained(ctx context.Context, c containers.Container) {
+	ctx, cancel := context.WithTimeout(ctx, r.draining.period)
+	defer cancel()
+	for {
+		if c.Health == "unhealthy" || !c.Running {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+		next, err := r.docker.Inspect(ctx, c.ID)
+		if err != nil {
+			if containers.IsNotFound(err) {
+				return
+			}
+			continue
+		}
+		c = next
+	}
+}
+
+// drainAll drains replicas in parallel, so scale-down takes one drain period
+// regardless of how many replicas are removed. It returns the number removed.
+func (r *Runner) drainAll(ctx context.Context, ids []string) int {
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		removed int
+	)
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if err := r.drainAndRemove(ctx, id); err != nil {
+				log.Printf("remove container %s failed: %v", shortID(id), err)
+				return
+			}
+			mu.Lock()
+			removed++
+			mu.Unlock()
+		}(id)
+	}
+	wg.Wait()
+	return removed
+}
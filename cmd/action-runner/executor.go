@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ilya2309548/EventPulse/internal/events"
+)
+
+// Param describes one parameter of an action kind. Parameters with a dedicated
+// event field (desired_replicas, target_runner) are read from that field.
+type Param struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"` // int, string, bool
+	Required    bool   `json:"required"`
+	Description string `json:"description,omitempty"`
+}
+
+// Executor runs one action kind.
+type Executor interface {
+	Kind() string
+	Params() []Param
+	// Timeout bounds one Execute call.
+	Timeout() time.Duration
+	// Validate rejects requests the executor cannot run; such actions fail
+	// without being attempted.
+	Validate(req events.ActionRequested) error
+	// Execute performs the action and returns outputs reported in action.completed.
+	Execute(ctx context.Context, req events.ActionRequested) (map[string]string, error)
+}
+
+// executorFactories is filled by init functions of the files implementing
+// each kind, so adding a kind does not touch the runner itself.
+var executorFactories []func(r *Runner) Executor
+
+func registerExecutor(f func(r *Runner) Executor) {
+	executorFactories = append(executorFactories, f)
+}
+
+// registry maps action kinds to executors.
+type registry map[string]Executor
+
+func newRegistry(r *Runner) registry {
+	reg := registry{}
+	for _, f := range executorFactories {
+		e := f(r)
+		reg[strings.ToLower(e.Kind())] = e
+	}
+	return reg
+}
+
+func (reg registry) lookup(kind string) (Executor, bool) {
+	e, ok := reg[strings.ToLower(kind)]
+	return e, ok
+}
+
+// KindInfo describes a supported kind on GET /kinds.
+type KindInfo struct {
+	Kind    string  `json:"kind"`
+	Params  []Param `json:"params"`
+	Timeout string  `json:"timeout"`
+}
+
+func (reg registry) describe() []KindInfo {
+	out := make([]KindInfo, 0, len(reg))
+	for _, e := range reg {
+		out = append(out, KindInfo{Kind: e.Kind(), Params: e.Params(), Timeout: e.Timeout().String()})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Kind < out[j].Kind })
+	return out
+}
+
+// handleKinds serves GET /kinds: the action kinds this runner executes.
+func (r *Runner) handleKinds(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"kinds": r.executors.describe()})
+}
+
+// paramValue returns a request parameter, including those lifted into event fields.
+func paramValue(req events.ActionRequested, name string) (any, bool) {
+	switch name {
+	case "desired_replicas":
+		return req.DesiredReplicas, req.DesiredReplicas != 0
+	case "target_runner":
+		return req.TargetRunner, req.TargetRunner != ""
+	}
+	v, ok := req.Params[name]
+	return v, ok
+}
+
+// validateParams checks required parameters and their types against a schema.
+func validateParams(req events.ActionRequested, params []Param) error {
+	for _, p := range params {
+		v, ok := paramValue(req, p.Name)
+		if !ok {
+			if p.Required {
+				return fmt.Errorf("%s: param %s is required", req.Kind, p.Name)
+			}
+			continue
+		}
+		valid := true
+		switch p.Type {
+		case "int":
+			switch n := v.(type) {
+			case int:
+			case float64:
+				valid = n == float64(int(n))
+			default:
+				valid = false
+			}
+		case "string":
+			_, valid = v.(string)
+		case "bool":
+			_, valid = v.(bool)
+		}
+		if !valid {
+			return fmt.Errorf("%s: param %s must be %s", req.Kind, p.Name, p.Type)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ilya2309548/EventPulse/internal/events"
+)
+
+func TestValidateParams(t *testing.T) {
+	schema := []Param{
+		{Name: "desired_replicas", Type: "int", Required: true},
+		{Name: "service", Type: "string"},
+		{Name: "dry_run", Type: "bool"},
+	}
+	tests := []struct {
+		name     string
+		replicas int
+		params   map[string]any
+		wantErr  string
+	}{
+		{"required from event field", 2, nil, ""},
+		{"all params", 2, map[string]any{"service": "api", "dry_run": true}, ""},
+		{"missing required", 0, nil, "param desired_replicas is required"},
+		{"optional missing", 1, map[string]any{}, ""},
+		{"wrong string type", 1, map[string]any{"service": 3.0}, "param service must be string"},
+		{"wrong bool type", 1, map[string]any{"dry_run": "yes"}, "param dry_run must be bool"},
+		{"unknown params are ignored", 1, map[string]any{"extra": []any{1}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := events.NewActionRequested("fp", events.KindScaleDocker)
+			req.DesiredReplicas = tt.replicas
+			req.Params = tt.params
+			err := validateParams(req, schema)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateIntParams(t *testing.T) {
+	schema := []Param{{Name: "count", Type: "int"}}
+	tests := []struct {
+		v    any
+		want bool
+	}{
+		{3, true},
+		{3.0, true},
+		{-1.0, true},
+		{2.5, false},
+		{"3", false},
+		{true, false},
+	}
+	for _, tt := range tests {
+		req := events.NewActionRequested("fp", "custom")
+		req.Params = map[string]any{"count": tt.v}
+		if err := validateParams(req, schema); (err == nil) != tt.want {
+			t.Errorf("count=%#v: err = %v, want valid=%v", tt.v, err, tt.want)
+		}
+	}
+}
+
+func TestParamValue(t *testing.T) {
+	req := events.NewActionRequested("fp", events.KindRestartRunner)
+	req.TargetRunner = "action-runner-a"
+	req.Params = map[string]any{"target_runner": "ignored", "x": "y"}
+	if v, ok := paramValue(req, "target_runner"); !ok || v != "action-runner-a" {
+		t.Errorf("target_runner = %v, %v; want the event field", v, ok)
+	}
+	if _, ok := paramValue(req, "desired_replicas"); ok {
+		t.Error("desired_replicas is unset but reported present")
+	}
+	if v, ok := paramValue(req, "x"); !ok || v != "y" {
+		t.Errorf("x = %v, %v", v, ok)
+	}
+}
+
+func TestRegistryLookupIgnoresCase(t *testing.T) {
+	reg := newRegistry(&Runner{})
+	for _, kind := range []string{events.KindScaleDocker, "SCALE_DOCKER", events.KindRestartRunner} {
+		if _, ok := reg.lookup(kind); !ok {
+			t.Errorf("kind %q not registered", kind)
+		}
+	}
+	if _, ok := reg.lookup("reboot_host"); ok {
+		t.Error("unknown kind found")
+	}
+	kinds := reg.describe()
+	for i := 1; i < len(kinds); i++ {
+		if kinds[i-1].Kind > kinds[i].Kind {
+			t.Errorf("kinds not sorted: %v", kinds)
+		}
+	}
+}
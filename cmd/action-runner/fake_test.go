@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ilya2309548/EventPulse/internal/containers"
+)
+
+// fakeEngine is an in-memory containers.Client. Containers are created
+// stopped and start immediately as healthy; err, when set, makes the named
+// operation fail, health overrides the health reported after start, and
+// ipAddress is the address of started containers.
+type fakeEngine struct {
+	mu         sync.Mutex
+	containers map[string]*containers.Container
+	seq        int
+	err        map[string]error
+	health     string
+	ipAddress  string
+	logLines   map[string]string
+}
+
+// newFakeEngine returns a fakeEngine holding the given containers.
+func newFakeEngine(initial ...containers.Container) *fakeEngine {
+	f := &fakeEngine{containers: map[string]*containers.Container{}, err: map[string]error{}, logLines: map[string]string{}}
+	for _, c := range initial {
+		c := c
+		if c.ID == "" {
+			f.seq++
+			c.ID = fmt.Sprintf("fake-%d", f.seq)
+		}
+		f.containers[c.ID] = &c
+	}
+	return f
+}
+
+func (f *fakeEngine) fail(op, id string) error {
+	if err := f.err[op]; err != nil {
+		return &containers.Error{Op: op, ID: id, Err: err}
+	}
+	return nil
+}
+
+// find looks a container up by id or name.
+func (f *fakeEngine) find(op, id string) (*containers.Container, error) {
+	if c, ok := f.containers[id]; ok {
+		return c, nil
+	}
+	for _, c := range f.containers {
+		if c.Name == id {
+			return c, nil
+		}
+	}
+	return nil, &containers.Error{Op: op, ID: id, Err: containers.ErrNotFound}
+}
+
+func (f *fakeEngine) List(_ context.Context, labels map[string]string, all bool) ([]containers.Container, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail("list", ""); err != nil {
+		return nil, err
+	}
+	var out []containers.Container
+	for _, c := range f.containers {
+		if !all && !c.Running {
+			continue
+		}
+		match := true
+		for k, v := range labels {
+			if c.Labels[k] != v {
+				match = false
+				break
+			}
+		}
+		if match {
+			out = append(out, *c)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Created.After(out[j].Created) })
+	return out, nil
+}
+
+func (f *fakeEngine) Inspect(_ context.Context, id string) (containers.Container, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail("inspect", id); err != nil {
+		return containers.Container{}, err
+	}
+	c, err := f.find("inspect", id)
+	if err != nil {
+		return containers.Container{}, err
+	}
+	return *c, nil
+}
+
+func (f *fakeEngine) Create(_ context.Context, spec containers.Spec) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail("create", spec.Name); err != nil {
+		return "", err
+	}
+	if spec.Name != "" {
+		if _, err := f.find("create", spec.Name); err == nil {
+			return "", &containers.Error{Op: "create", ID: spec.Name, Err: containers.ErrConflict}
+		}
+	}
+	f.seq++
+	id := fmt.Sprintf("fake-%d", f.seq)
+	labels := make(map[string]string, len(spec.Labels))
+	for k, v := range spec.Labels {
+		labels[k] = v
+	}
+	spec.Labels = labels
+	f.containers[id] = &containers.Container{ID: id, Name: spec.Name, Image: spec.Image, Labels: labels, Created: time.Now().UTC(), Spec: spec}
+	return id, nil
+}
+
+func (f *fakeEngine) Start(_ context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail("start", id); err != nil {
+		return err
+	}
+	c, err := f.find("start", id)
+	if err != nil {
+		return err
+	}
+	c.Running = true
+	c.IPAddress = f.ipAddress
+	c.Health = "healthy"
+	if f.health != "" {
+		c.Health = f.health
+	}
+	return nil
+}
+
+func (f *fakeEngine) Stop(_ context.Context, id string, _ time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail("stop", id); err != nil {
+		return err
+	}
+	c, err := f.find("stop", id)
+	if err != nil {
+		return err
+	}
+	c.Running = false
+	c.IPAddress = ""
+	c.Health = ""
+	return nil
+}
+
+func (f *fakeEngine) Remove(_ context.Context, id string, force bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail("remove", id); err != nil {
+		return err
+	}
+	c, err := f.find("remove", id)
+	if err != nil {
+		return err
+	}
+	if c.Running && !force {
+		return &containers.Error{Op: "remove", ID: id, Err: containers.ErrConflict}
+	}
+	delete(f.containers, c.ID)
+	return nil
+}
+
+func (f *fakeEngine) Logs(_ context.Context, id string, _ int) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.fail("logs", id); err != nil {
+		return "", err
+	}
+	c, err := f.find("logs", id)
+	if err != nil {
+		return "", err
+	}
+	return f.logLines[c.ID], nil
+}
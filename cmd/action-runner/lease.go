@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ilya2309548/EventPulse/internal/events"
+)
+
+// Leaser is implemented by executors whose actions mutate a shared target
+// (a service's replica set, a runner container). Such actions hold the
+// target's lease while executing, so runners never act on it concurrently.
+type Leaser interface {
+	LeaseKey(req events.ActionRequested) string
+}
+
+// leasing configures target leases. Leases live in target_leases in the
+// action DB shared by all runners and expire unless renewed, so a crashed
+// holder blocks a target for at most ttl.
+type leasing struct {
+	holder  string        // RUNNER_ID, the hostname by default
+	ttl     time.Duration // LEASE_TTL
+	maxWait time.Duration // LEASE_MAX_WAIT
+	poll    time.Duration
+}
+
+// errSuperseded is returned while waiting for a lease when a newer action for
+// the same target arrived; only the newest desired state is applied.
+type errSuperseded struct{ by string }
+
+func (e errSuperseded) Error() string { return "superseded by " + e.by }
+
+func migrateLeases(db *sql.DB) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS target_leases (
+			target TEXT PRIMARY KEY,
+			holder TEXT NOT NULL,
+			action_id TEXT NOT NULL,
+			acquired_at TIMESTAMPTZ NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL
+		)`,
+		`ALTER TABLE action_exec ADD COLUMN IF NOT EXISTS target TEXT`,
+		`ALTER TABLE action_exec ADD COLUMN IF NOT EXISTS requested_at TEXT`,
+		`ALTER TABLE action_exec ADD COLUMN IF NOT EXISTS lock_holder TEXT`,
+		`ALTER TABLE action_exec ADD COLUMN IF NOT EXISTS lock_wait_ms BIGINT`,
+		`CREATE INDEX IF NOT EXISTS idx_action_exec_target ON action_exec(target, status)`,
+	}
+	for _, s := range stmts {
+		if _, err := db.Exec(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tryLease takes the lease on key for actionID if it is free, expired or
+// already ours. It returns the current holder's action when it is taken.
+func (r *Runner) tryLease(ctx context.Context, key, actionID string) (bool, string, error) {
+	var got string
+	err := r.db.QueryRowContext(ctx, `INSERT INTO target_leases (target, holder, action_id, acquired_at, expires_at)
+		VALUES ($1, $2, $3, now(), now() + $4 * interval '1 millisecond')
+		ON CONFLICT (target) DO UPDATE SET holder=EXCLUDED.holder, action_id=EXCLUDED.action_id,
+			acquired_at=EXCLUDED.acquired_at, expires_at=EXCLUDED.expires_at
+		WHERE target_leases.expires_at < now() OR target_leases.action_id = EXCLUDED.action_id
+		RETURNING action_id`, key, r.leasing.holder, actionID, r.leasing.ttl.Milliseconds()).Scan(&got)
+	if err == nil {
+		return true, "", nil
+	}
+	if err != sql.ErrNoRows {
+		return false, "", err
+	}
+	var holder, other string
+	err = r.db.QueryRowContext(ctx, `SELECT holder, action_id FROM target_leases WHERE target=$1`, key).Scan(&holder, &other)
+	if err == sql.ErrNoRows {
+		return false, "", nil // released meanwhile; retry
+	}
+	return false, fmt.Sprintf("%s (%s)", other, holder), err
+}
+
+// newerAction returns a pending action for the same target requested after req.
+func (r *Runner) newerAction(ctx context.Context, key string, req events.ActionRequested) (string, error) {
+	var id string
+	err := r.db.QueryRowContext(ctx, `SELECT action_id FROM action_exec
+		WHERE target=$1 AND action_id<>$2 AND status IN ('waiting','running','retrying')
+		AND (requested_at > $3 OR (requested_at = $3 AND id > (SELECT id FROM action_exec WHERE action_id=$2)))
+		ORDER BY requested_at DESC, id DESC LIMIT 1`, key, req.ActionID, req.CreatedAt).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return id, err
+}
+
+// acquireLease waits until req holds the lease on key. The returned context
+// is cancelled when the lease is lost; release must be called when done.
+// While waiting, the action is superseded if a newer one for the same target
+// shows up.
+func (r *Runner) acquireLease(ctx context.Context, key string, req events.ActionRequested) (context.Context, func(), error) {
+	start := time.Now()
+	if _, err := r.db.ExecContext(ctx, `UPDATE action_exec SET target=$1, requested_at=$2, status='waiting' WHERE action_id=$3`,
+		key, req.CreatedAt, req.ActionID); err != nil {
+		return nil, nil, err
+	}
+	if err := appendHistory(r.db, req.ActionID, "waiting", "lease "+key, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return nil, nil, err
+	}
+	deadline := time.NewTimer(r.leasing.maxWait)
+	defer deadline.Stop()
+	for {
+		ok, holder, err := r.tryLease(ctx, key, req.ActionID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok {
+			break
+		}
+		newer, err := r.newerAction(ctx, key, req)
+		if err != nil {
+			return nil, nil, err
+		}
+		if newer != "" {
+			return nil, nil, errSuperseded{by: newer}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-deadline.C:
+			return nil, nil, fmt.Errorf("lease on %s not acquired within %s, held by %s", key, r.leasing.maxWait, holder)
+		case <-time.After(r.leasing.poll):
+		}
+	}
+	waited := time.Since(start)
+	if waited > r.leasing.poll {
+		log.Printf("action %s: acquired lease on %s after %s", req.ActionID, key, waited.Round(time.Millisecond))
+	}
+	if _, err := r.db.ExecContext(ctx, `UPDATE action_exec SET status='running', lock_holder=$1, lock_wait_ms=$2 WHERE action_id=$3`,
+		r.leasing.holder, waited.Milliseconds(), req.ActionID); err != nil {
+		r.releaseLease(key, req.ActionID)
+		return nil, nil, err
+	}
+	if err := appendHistory(r.db, req.ActionID, "running", "lease held by "+r.leasing.holder, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		r.releaseLease(key, req.ActionID)
+		return nil, nil, err
+	}
+
+	// Renew at a third of the ttl; losing the lease cancels the action.
+	leaseCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(r.leasing.ttl / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				res, err := r.db.Exec(`UPDATE target_leases SET expires_at = now() + $1 * interval '1 millisecond'
+					WHERE target=$2 AND action_id=$3`, r.leasing.ttl.Milliseconds(), key, req.ActionID)
+				if err != nil {
+					log.Printf("renew lease %s: %v", key, err)
+					continue
+				}
+				if n, _ := res.RowsAffected(); n == 0 {
+					log.Printf("action %s: lost lease on %s", req.ActionID, key)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	release := func() {
+		close(done)
+		cancel()
+		r.releaseLease(key, req.ActionID)
+	}
+	return leaseCtx, release, nil
+}
+
+func (r *Runner) releaseLease(key, actionID string) {
+	if _, err := r.db.Exec(`DELETE FROM target_leases WHERE target=$1 AND action_id=$2`, key, actionID); err != nil {
+		// The lease expires on its own
+		log.Printf("release lease %s: %v", key, err)
+	}
+}
+
+func isSuperseded(err error) (string, bool) {
+	var s errSuperseded
+	if errors.As(err, &s) {
+		return s.by, true
+	}
+	return "", false
+}
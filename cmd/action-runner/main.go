@@ -1,17 +1,16 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
@@ -19,16 +18,31 @@ import (
 	kafka "github.com/segmentio/kafka-go"
 
 	"github.com/ilya2309548/EventPulse/internal/common"
+	"github.com/ilya2309548/EventPulse/internal/consumer"
+	"github.com/ilya2309548/EventPulse/internal/containers"
+	"github.com/ilya2309548/EventPulse/internal/dlq"
+	"github.com/ilya2309548/EventPulse/internal/events"
+	"github.com/ilya2309548/EventPulse/internal/outbox"
+	"github.com/ilya2309548/EventPulse/internal/storage"
 )
 
 type Runner struct {
 	db            *sql.DB
 	ready         bool
 	reader        *kafka.Reader
-	completedSink *kafka.Writer
-	failedSink    *kafka.Writer
-	dockerImage   string
+	relay         *outbox.Relay
+	docker        containers.Client
+	executors     registry
+	readiness     readiness
+	draining      draining
+	leasing       leasing
+	templates     map[string]containers.Spec
 	dockerNetwork string
+	maxTimeout    time.Duration // ACTION_MAX_TIMEOUT caps timeout_seconds
+	cancelPoll    time.Duration
+
+	activeMu sync.Mutex
+	active   map[string]context.CancelCauseFunc // actions running on this runner
 }
 
 func migrate(db *sql.DB) error {
@@ -55,13 +69,25 @@ func migrate(db *sql.DB) error {
 			created_at TEXT NOT NULL,
 			updated_at TEXT NOT NULL
 		)`,
+		`ALTER TABLE action_exec ADD COLUMN IF NOT EXISTS incident_id TEXT`,
+		`ALTER TABLE action_exec ADD COLUMN IF NOT EXISTS correlation_id TEXT`,
+		`ALTER TABLE action_exec ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0`,
 	}
 	for _, s := range stmts {
 		if _, err := db.Exec(s); err != nil {
 			return err
 		}
 	}
-	return nil
+	if err := storage.MigrateOutbox(db); err != nil {
+		return err
+	}
+	if err := migrateLeases(db); err != nil {
+		return err
+	}
+	if err := migrateCancels(db); err != nil {
+		return err
+	}
+	return dlq.Migrate(db)
 }
 
 func (r *Runner) handleHealth(w http.ResponseWriter, _ *http.Request) {
@@ -79,326 +105,186 @@ func (r *Runner) handleReady(w http.ResponseWriter, _ *http.Request) {
 	_, _ = w.Write([]byte("not-ready"))
 }
 
-func insertInbox(db *sql.DB, key, now string) error {
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func insertInbox(db storage.Querier, key, now string) error {
 	_, err := db.Exec(`INSERT INTO inbox (dedup_key, created_at) VALUES ($1,$2)`, key, now)
 	return err
 }
 
-func writeOutbox(db *sql.DB, typ, payload, now string) error {
+func writeOutbox(db storage.Querier, typ, payload, now string) error {
 	_, err := db.Exec(`INSERT INTO outbox_events (type, payload, created_at) VALUES ($1,$2,$3)`, typ, payload, now)
 	return err
 }
 
-func (r *Runner) recordAction(actionID, kind string, desired int, alertFP, status, errText, now string) error {
+func recordAction(db storage.Querier, req events.ActionRequested, status, errText, now string) error {
 	// Upsert-like by action_id
-	_, err := r.db.Exec(`INSERT INTO action_exec (action_id, kind, desired_replicas, alert_fp, status, error, created_at, updated_at)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$7)
+	_, err := db.Exec(`INSERT INTO action_exec (action_id, kind, desired_replicas, alert_fp, incident_id, correlation_id, status, error, created_at, updated_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$9)
 		ON CONFLICT (action_id) DO UPDATE SET status=EXCLUDED.status, error=EXCLUDED.error, updated_at=EXCLUDED.updated_at`,
-		actionID, kind, desired, alertFP, status, errText, now,
+		req.ActionID, req.Kind, req.DesiredReplicas, req.AlertFP, req.IncidentID, req.CorrelationID, status, errText, now,
 	)
-	return err
-}
-
-func (r *Runner) publishResult(ctx context.Context, topic string, payload map[string]any) error {
-	pjson, _ := json.Marshal(payload)
-	now := time.Now().UTC().Format(time.RFC3339)
-	if err := writeOutbox(r.db, payload["type"].(string), string(pjson), now); err != nil {
+	if err != nil {
 		return err
 	}
-	var w *kafka.Writer
-	switch topic {
-	case r.completedSink.Topic:
-		w = r.completedSink
-	case r.failedSink.Topic:
-		w = r.failedSink
-	default:
-		return errors.New("unknown topic")
-	}
-	return w.WriteMessages(ctx, kafka.Message{Value: pjson})
+	return appendHistory(db, req.ActionID, status, errText, now)
 }
 
-func (r *Runner) listAppContainers(ctx context.Context) ([]struct {
-	ID        string
-	ManagedBy string
-}, error) {
-	// Use docker CLI to list containers by label service=app
-	cmd := exec.CommandContext(ctx, "docker", "ps", "-q", "--filter", "label=service=app")
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	if err := cmd.Run(); err != nil {
-		return nil, err
-	}
-	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
-	var res []struct {
-		ID        string
-		ManagedBy string
-	}
-	for _, id := range lines {
-		id = strings.TrimSpace(id)
-		if id == "" {
-			continue
+// finishAction records the final action status and writes the result event to
+// the outbox in one transaction; the relay publishes it. An empty status is
+// derived from the result type.
+func (r *Runner) finishAction(req events.ActionRequested, res events.ActionResult, status string) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	if status == "" {
+		status = "completed"
+		switch res.Type {
+		case events.TypeActionFailed:
+			status = "failed"
+		case events.TypeActionCancelled:
+			status = "cancelled"
 		}
-		// Get managed-by label for preference on removals
-		lblCmd := exec.CommandContext(ctx, "docker", "inspect", "-f", "{{ index .Config.Labels \"managed-by\" }}", id)
-		var lblOut bytes.Buffer
-		lblCmd.Stdout = &lblOut
-		_ = lblCmd.Run()
-		res = append(res, struct {
-			ID        string
-			ManagedBy string
-		}{ID: id, ManagedBy: strings.TrimSpace(lblOut.String())})
-	}
-	return res, nil
-}
-
-func (r *Runner) createAppReplica(ctx context.Context) (string, error) {
-	img := strings.TrimSpace(r.dockerImage)
-	if img == "" {
-		img = "eventpulse-app:latest"
-	}
-	name := fmt.Sprintf("app-replica-%d", time.Now().UnixNano())
-	args := []string{
-		"run", "-d",
-		"--label", "traefik.enable=true",
-		"--label", "traefik.http.routers.app.rule=Path(`/work`)",
-		"--label", "traefik.http.routers.app.entrypoints=web",
-		"--label", "traefik.http.services.app.loadbalancer.server.port=8080",
-		"--label", "service=app",
-		"--label", "managed-by=action-runner",
-		"--env", "SERVICE=app",
-		"--env", "APP_GOMAXPROCS=1",
-		"--restart", "always",
-	}
-	if r.dockerNetwork != "" {
-		args = append(args, "--network", r.dockerNetwork)
-	}
-	args = append(args, "--name", name, img)
-	cmd := exec.CommandContext(ctx, "docker", args...)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &out
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("docker run failed: %s", out.String())
-	}
-	return strings.TrimSpace(out.String()), nil
-}
-
-func (r *Runner) removeContainer(ctx context.Context, id string) error {
-	// Stop and remove force
-	stop := exec.CommandContext(ctx, "docker", "rm", "-f", id)
-	var out bytes.Buffer
-	stop.Stdout = &out
-	stop.Stderr = &out
-	if err := stop.Run(); err != nil {
-		return fmt.Errorf("docker rm failed: %s", out.String())
 	}
-	return nil
-}
-
-// executeRestartRunner starts a stopped runner container by its compose service name (e.g. "action-runner-a").
-// It finds the container by label com.docker.compose.service and calls `docker start`.
-// Optionally waits for the runner's health endpoint to respond.
-func (r *Runner) executeRestartRunner(targetService string) error {
-	svc := strings.TrimSpace(targetService)
-	if svc == "" {
-		return errors.New("target_runner is required")
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	// Find container id by compose service label
-	find := exec.CommandContext(ctx, "docker", "ps", "-aq", "--filter", fmt.Sprintf("label=com.docker.compose.service=%s", svc))
-	var out bytes.Buffer
-	find.Stdout = &out
-	if err := find.Run(); err != nil {
-		return fmt.Errorf("docker ps failed: %v", err)
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
 	}
-	id := strings.TrimSpace(out.String())
-	if id == "" {
-		return fmt.Errorf("container for service %s not found", svc)
+	defer func() { _ = tx.Rollback() }()
+	if err := recordAction(tx, req, status, res.Error, now); err != nil {
+		return err
 	}
-
-	// If already running, treat as success
-	inspect := exec.CommandContext(ctx, "docker", "inspect", "-f", "{{.State.Running}}", id)
-	var inspOut bytes.Buffer
-	inspect.Stdout = &inspOut
-	_ = inspect.Run()
-	if strings.Contains(strings.ToLower(inspOut.String()), "true") {
-		return nil
+	// A cancel request is settled once the action finishes, however it ended
+	if _, err := tx.Exec(`UPDATE action_cancels SET handled_at=$1 WHERE action_id=$2 AND handled_at IS NULL`, now, req.ActionID); err != nil {
+		return err
 	}
-
-	// Start container
-	start := exec.CommandContext(ctx, "docker", "start", id)
-	var startOut bytes.Buffer
-	start.Stdout = &startOut
-	start.Stderr = &startOut
-	if err := start.Run(); err != nil {
-		return fmt.Errorf("docker start failed: %s", startOut.String())
+	pjson, _ := json.Marshal(res)
+	if err := writeOutbox(tx, res.Type, string(pjson), now); err != nil {
+		return err
 	}
-
-	// Best-effort readiness probe against runner health
-	client := &http.Client{Timeout: 2 * time.Second}
-	url := fmt.Sprintf("http://%s:8092/health", svc)
-	deadline := time.Now().Add(15 * time.Second)
-	for time.Now().Before(deadline) {
-		resp, err := client.Get(url)
-		if err == nil && resp.StatusCode == 200 {
-			return nil
-		}
-		time.Sleep(1 * time.Second)
+	if err := tx.Commit(); err != nil {
+		return err
 	}
-	// If health didn't come up within deadline, still return success (container started)
+	r.relay.Notify()
 	return nil
 }
 
-func (r *Runner) executeScale(kind string, desired int) error {
-	if strings.ToLower(kind) != "scale_docker" {
-		return fmt.Errorf("unsupported action kind: %s", kind)
+// actionStatus returns the status recorded in action_exec, or "" if none.
+func (r *Runner) actionStatus(actionID string) (string, error) {
+	var status string
+	err := r.db.QueryRow(`SELECT status FROM action_exec WHERE action_id=$1`, actionID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return "", nil
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
+	return status, err
+}
 
-	cur, err := r.listAppContainers(ctx)
+// processAction executes one action.requested message. An action that was
+// started but never finished (runner crashed mid-way) is executed again on
+// redelivery; scale actions converge, so repeating them is safe.
+func (r *Runner) processAction(ctx context.Context, msg kafka.Message) error {
+	env, err := events.Peek(msg.Value)
 	if err != nil {
-		return err
+		return consumer.Permanent(err)
 	}
-	if len(cur) < desired {
-		missing := desired - len(cur)
-		for i := 0; i < missing; i++ {
-			if _, err := r.createAppReplica(ctx); err != nil {
-				return fmt.Errorf("create replica: %w", err)
-			}
-		}
-	} else if len(cur) > desired {
-		// Prefer removing managed-by=action-runner and the newest ones
-		// No created timestamp via CLI, rely on managed-by preference
-		toRemove := len(cur) - desired
-		removed := 0
-		for _, c := range cur {
-			if removed >= toRemove {
-				break
-			}
-			if c.ManagedBy == "action-runner" {
-				if err := r.removeContainer(ctx, c.ID); err != nil {
-					log.Printf("remove container %s failed: %v", c.ID, err)
-					continue
-				}
-				removed++
-			}
-		}
-		if removed < toRemove {
-			cur2, _ := r.listAppContainers(ctx)
-			for _, c := range cur2 {
-				if removed >= toRemove {
-					break
-				}
-				if err := r.removeContainer(ctx, c.ID); err != nil {
-					log.Printf("force remove container %s failed: %v", c.ID, err)
-					continue
-				}
-				removed++
-			}
-		}
+	if env.Type != events.TypeActionRequested {
+		return nil
 	}
-	final, err := r.listAppContainers(ctx)
+	req, err := events.DecodeActionRequested(msg.Value)
 	if err != nil {
-		return err
-	}
-	if len(final) != desired {
-		return fmt.Errorf("replica convergence failed: have=%d desired=%d", len(final), desired)
-	}
-	return nil
-}
-
-func (r *Runner) processAction(msg kafka.Message) error {
-	var m map[string]any
-	if err := json.Unmarshal(msg.Value, &m); err != nil {
-		return err
-	}
-	if m["type"] != "action.requested" {
-		return nil
+		return consumer.Permanent(err)
 	}
 	now := time.Now().UTC().Format(time.RFC3339)
-	// Prefer explicit action_id for idempotency; fall back to dedup_key
-	actionID, _ := m["action_id"].(string)
-	dedup, _ := m["dedup_key"].(string)
-	if actionID == "" {
-		actionID = dedup
-	}
-	if actionID == "" {
-		actionID = fmt.Sprintf("act-%d", time.Now().UnixNano())
-	}
-	if dedup == "" {
-		dedup = actionID
-	}
+	actionID, kind := req.ActionID, req.Kind
 	// Inbox dedup
-	if err := insertInbox(r.db, dedup, now); err != nil {
-		if strings.Contains(strings.ToLower(err.Error()), "unique") || strings.Contains(strings.ToLower(err.Error()), "duplicate") {
+	if err := insertInbox(r.db, req.DedupKey, now); err != nil {
+		if !strings.Contains(strings.ToLower(err.Error()), "unique") && !strings.Contains(strings.ToLower(err.Error()), "duplicate") {
+			return err
+		}
+		status, err := r.actionStatus(actionID)
+		if err != nil {
+			return err
+		}
+		if status != "" && status != "running" && status != "waiting" && status != "retrying" {
 			return nil
 		}
-		return err
-	}
-	kind, _ := m["kind"].(string)
-	desired := 1
-	if v, ok := m["desired_replicas"].(float64); ok {
-		desired = int(v)
+		log.Printf("resuming unfinished action %s (%s)", actionID, kind)
 	}
-	alertFP, _ := m["alert_fp"].(string)
-	targetRunner, _ := m["target_runner"].(string)
 
 	// Record start
-	if err := r.recordAction(actionID, kind, desired, alertFP, "running", "", now); err != nil {
-		log.Printf("record action start failed: %v", err)
+	if err := recordAction(r.db, req, "running", "", now); err != nil {
+		return err
 	}
 
-	// Execute requested action
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-	var execErr error
-	switch strings.ToLower(kind) {
-	case "scale_docker":
-		execErr = r.executeScale(kind, desired)
-	case "restart_runner":
-		execErr = r.executeRestartRunner(targetRunner)
-	default:
-		execErr = fmt.Errorf("unsupported action kind: %s", kind)
+	// Cancelled before any runner got to it
+	if reason, ok, err := r.cancelRequested(ctx, actionID); err != nil {
+		return err
+	} else if ok {
+		log.Printf("action %s cancelled before start (%s)", actionID, reason)
+		return r.finishAction(req, events.NewActionCancelled(req, reason), "")
+	}
+
+	// Execute requested action; POST /actions/{id}/cancel or action.cancel
+	// cancels ctx with errCancelled as the cause
+	ctx, cancel := context.WithCancelCause(ctx)
+	r.track(actionID, cancel)
+	defer r.untrack(actionID)
+	outputs, attempts, execErr := r.execute(ctx, req)
+	if reason, ok := isCancelled(context.Cause(ctx)); ok && execErr != nil {
+		log.Printf("action %s cancelled (%s): %v", actionID, reason, execErr)
+		res := events.NewActionCancelled(req, reason)
+		res.Attempt = attempts
+		return r.finishAction(req, res, "")
+	}
+	if by, ok := isSuperseded(execErr); ok {
+		// A newer desired state for the same target wins. Nothing was done, so
+		// it is reported as cancelled, not as a completed mitigation
+		log.Printf("action %s superseded by %s", actionID, by)
+		res := events.NewActionCancelled(req, "superseded by "+by)
+		res.Outputs = map[string]string{"superseded_by": by}
+		res.Attempt = attempts
+		return r.finishAction(req, res, "superseded")
 	}
 	if execErr != nil {
-		// Failure path
-		_ = r.recordAction(actionID, kind, desired, alertFP, "failed", execErr.Error(), now)
-		payload := map[string]any{
-			"type":             "action.failed",
-			"action_id":        actionID,
-			"kind":             kind,
-			"desired_replicas": desired,
-			"alert_fp":         alertFP,
-			"error":            execErr.Error(),
-			"target_runner":    targetRunner,
-			"created_at":       now,
-			"dedup_key":        actionID + ":failed",
-		}
-		if perr := r.publishResult(ctx, r.failedSink.Topic, payload); perr != nil {
-			log.Printf("publish action.failed error: %v", perr)
-		}
-		return nil
+		// Failure path: not retryable or out of attempts
+		res := events.NewActionFailed(req, execErr)
+		res.Attempt = attempts
+		return r.finishAction(req, res, "")
 	}
-
 	// Success path
-	_ = r.recordAction(actionID, kind, desired, alertFP, "completed", "", now)
-	payload := map[string]any{
-		"type":             "action.completed",
-		"action_id":        actionID,
-		"kind":             kind,
-		"desired_replicas": desired,
-		"alert_fp":         alertFP,
-		"target_runner":    targetRunner,
-		"created_at":       now,
-		"dedup_key":        actionID + ":completed",
-	}
-	if perr := r.publishResult(ctx, r.completedSink.Topic, payload); perr != nil {
-		log.Printf("publish action.completed error: %v", perr)
+	res := events.NewActionCompleted(req)
+	res.Outputs = outputs
+	res.Attempt = attempts
+	return r.finishAction(req, res, "")
+}
+
+// execute runs req with the executor registered for its kind, retrying failed
+// attempts according to the executor's retry policy. It returns the number of
+// attempts made.
+func (r *Runner) execute(ctx context.Context, req events.ActionRequested) (map[string]string, int, error) {
+	ex, ok := r.executors.lookup(req.Kind)
+	if !ok {
+		return nil, 0, fmt.Errorf("unsupported action kind: %s", req.Kind)
 	}
-	return nil
+	if err := validateParams(req, ex.Params()); err != nil {
+		return nil, 0, err
+	}
+	if err := ex.Validate(req); err != nil {
+		return nil, 0, err
+	}
+	leaseKey := ""
+	if l, ok := ex.(Leaser); ok {
+		leaseKey = l.LeaseKey(req)
+		leaseCtx, release, err := r.acquireLease(ctx, leaseKey, req)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer release()
+		ctx = leaseCtx
+	}
+	return r.runAttempts(ctx, ex, req, leaseKey)
 }
 
 func main() {
@@ -435,22 +321,110 @@ func main() {
 	if topicFailed == "" {
 		topicFailed = "action.failed"
 	}
+	topicRetrying := os.Getenv("KAFKA_TOPIC_ACTION_RETRYING")
+	if topicRetrying == "" {
+		topicRetrying = "action.retrying"
+	}
+	topicCancel := os.Getenv("KAFKA_TOPIC_ACTION_CANCEL")
+	if topicCancel == "" {
+		topicCancel = "action.cancel"
+	}
+	topicCancelled := os.Getenv("KAFKA_TOPIC_ACTION_CANCELLED")
+	if topicCancelled == "" {
+		topicCancelled = "action.cancelled"
+	}
 
 	reader := kafka.NewReader(kafka.ReaderConfig{
 		Brokers: brokers,
 		Topic:   topicIn,
 		GroupID: "action-runner",
 	})
-	completedWriter := &kafka.Writer{Addr: kafka.TCP(brokers...), Topic: topicCompleted, Balancer: &kafka.LeastBytes{}}
-	failedWriter := &kafka.Writer{Addr: kafka.TCP(brokers...), Topic: topicFailed, Balancer: &kafka.LeastBytes{}}
+	completedWriter := &kafka.Writer{Addr: kafka.TCP(brokers...), Topic: topicCompleted, Balancer: &kafka.LeastBytes{}, RequiredAcks: kafka.RequireAll}
+	failedWriter := &kafka.Writer{Addr: kafka.TCP(brokers...), Topic: topicFailed, Balancer: &kafka.LeastBytes{}, RequiredAcks: kafka.RequireAll}
+	retryingWriter := &kafka.Writer{Addr: kafka.TCP(brokers...), Topic: topicRetrying, Balancer: &kafka.LeastBytes{}, RequiredAcks: kafka.RequireAll}
+	cancelledWriter := &kafka.Writer{Addr: kafka.TCP(brokers...), Topic: topicCancelled, Balancer: &kafka.LeastBytes{}, RequiredAcks: kafka.RequireAll}
+	relay := outbox.NewRelay(db, map[string]*kafka.Writer{
+		events.TypeActionCompleted: completedWriter,
+		events.TypeActionFailed:    failedWriter,
+		events.TypeActionRetrying:  retryingWriter,
+		events.TypeActionCancelled: cancelledWriter,
+	})
+	go relay.Run(context.Background())
 
-	dockerImage := strings.TrimSpace(os.Getenv("DOCKER_IMAGE"))
 	dockerNetwork := strings.TrimSpace(os.Getenv("DOCKER_NETWORK"))
 
-	r := &Runner{db: db, ready: true, reader: reader, completedSink: completedWriter, failedSink: failedWriter, dockerImage: dockerImage, dockerNetwork: dockerNetwork}
+	docker, err := containers.NewEngine()
+	if err != nil {
+		log.Fatalf("docker client: %v", err)
+	}
+
+	r := &Runner{db: db, ready: true, reader: reader, relay: relay, docker: docker, dockerNetwork: dockerNetwork,
+		maxTimeout: 30 * time.Minute, cancelPoll: time.Second, active: map[string]context.CancelCauseFunc{}}
+	if v := strings.TrimSpace(os.Getenv("ACTION_MAX_TIMEOUT")); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			r.maxTimeout = d
+		}
+	}
+	// Replica specs are cloned from each service's baseline container unless
+	// REPLICA_TEMPLATE_FILE provides one
+	if path := strings.TrimSpace(os.Getenv("REPLICA_TEMPLATE_FILE")); path != "" {
+		templates, err := loadTemplates(path)
+		if err != nil {
+			log.Fatalf("replica templates: %v", err)
+		}
+		r.templates = templates
+	}
+	r.readiness = readiness{timeout: 45 * time.Second, interval: time.Second, path: "/healthz", port: 8080, logTail: 20}
+	if v := strings.TrimSpace(os.Getenv("READINESS_TIMEOUT")); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			r.readiness.timeout = d
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("READINESS_PROBE_PATH")); v != "" {
+		r.readiness.path = v
+	}
+	if v := strings.TrimSpace(os.Getenv("READINESS_PROBE_PORT")); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			r.readiness.port = n
+		}
+	}
+	r.draining = draining{period: 30 * time.Second, grace: 20 * time.Second, path: "/drain"}
+	if v := strings.TrimSpace(os.Getenv("SCALE_DOWN_DRAIN")); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			r.draining.period = d
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("SCALE_DOWN_STOP_GRACE")); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			r.draining.grace = d
+		}
+	}
+	r.leasing = leasing{holder: strings.TrimSpace(os.Getenv("RUNNER_ID")), ttl: 30 * time.Second, maxWait: 5 * time.Minute, poll: time.Second}
+	if r.leasing.holder == "" {
+		r.leasing.holder, _ = os.Hostname()
+	}
+	if v := strings.TrimSpace(os.Getenv("LEASE_TTL")); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 3*time.Second {
+			r.leasing.ttl = d
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("LEASE_MAX_WAIT")); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			r.leasing.maxWait = d
+		}
+	}
+	r.executors = newRegistry(r)
 
 	http.HandleFunc("/health", r.handleHealth)
 	http.HandleFunc("/ready", r.handleReady)
+	http.HandleFunc("/kinds", r.handleKinds)
+	http.HandleFunc("/replicas", r.handleReplicas)
+	http.HandleFunc("/actions", r.handleActions)
+	http.HandleFunc("/actions/", r.handleAction)
+
+	// Dead-letter queue: failed messages go to <topic>.dlq and can be replayed
+	deadLetters := dlq.New(db, brokers, "action-runner")
+	deadLetters.Register(http.DefaultServeMux)
 	go func() {
 		log.Printf("action-runner listening on :8092")
 		if err := http.ListenAndServe(":8092", nil); err != nil {
@@ -458,17 +432,20 @@ func main() {
 		}
 	}()
 
+	// Cancellation requests: any runner records them, the one executing the
+	// action picks them up through watchCancels
+	go r.watchCancels(context.Background())
+	cancelReader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: brokers,
+		Topic:   topicCancel,
+		GroupID: "action-runner-cancel",
+	})
+	cancels := &consumer.Consumer{Name: "action-runner-cancel", Reader: cancelReader, OnFailure: deadLetters.Add, Handler: r.processCancel}
+	cancels.LoadEnv()
+	go cancels.Run(context.Background())
+
 	log.Printf("action-runner consuming from %s", topicIn)
-	for {
-		ctx := context.Background()
-		msg, err := reader.ReadMessage(ctx)
-		if err != nil {
-			log.Printf("read error: %v", err)
-			time.Sleep(1 * time.Second)
-			continue
-		}
-		if err := r.processAction(msg); err != nil {
-			log.Printf("process error: %v", err)
-		}
-	}
+	c := &consumer.Consumer{Name: "action-runner", Reader: reader, OnFailure: deadLetters.Add, Handler: r.processAction}
+	c.LoadEnv()
+	c.Run(context.Background())
 }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// readiness configures how new replicas are checked before an action completes.
+type readiness struct {
+	timeout  time.Duration // READINESS_TIMEOUT
+	interval time.Duration
+	path     string // READINESS_PROBE_PATH
+	port     int    // READINESS_PROBE_PORT
+	logTail  int
+}
+
+// waitReady blocks until the container is ready or the readiness deadline
+// passes. A container with a Docker healthcheck is ready when it reports
+// healthy; otherwise when the HTTP probe answers 2xx. On failure the error
+// carries the tail of the container's logs.
+func (r *Runner) waitReady(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.readiness.timeout)
+	defer cancel()
+	client := &http.Client{Timeout: 2 * time.Second}
+	var last string
+	for {
+		c, err := r.docker.Inspect(ctx, id)
+		switch {
+		case err != nil:
+			last = err.Error()
+		case !c.Running:
+			return r.notReady(id, "container exited")
+		case c.Health == "unhealthy":
+			return r.notReady(id, "healthcheck reports unhealthy")
+		case c.Health == "healthy":
+			return nil
+		case c.Health != "":
+			last = "health " + c.Health
+		default:
+			host := c.IPAddress
+			if host == "" {
+				host = c.Name
+			}
+			url := fmt.Sprintf("http://%s:%d%s", host, r.readiness.port, r.readiness.path)
+			resp, err := client.Get(url)
+			if err != nil {
+				last = err.Error()
+				break
+			}
+			resp.Body.Close()
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				return nil
+			}
+			last = fmt.Sprintf("%s: %s", url, resp.Status)
+		}
+		select {
+		case <-ctx.Done():
+			return r.notReady(id, fmt.Sprintf("not ready after %s (%s)", r.readiness.timeout, last))
+		case <-time.After(r.readiness.interval):
+		}
+	}
+}
+
+// notReadyError reports a new replica that did not become ready. A slow
+// start is often transient, so scale actions retry it.
+type notReadyError struct{ msg string }
+
+func (e notReadyError) Error() string { return e.msg }
+
+func isNotReady(err error) bool {
+	var e notReadyError
+	return errors.As(err, &e)
+}
+
+func (r *Runner) notReady(id, reason string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	logs, err := r.docker.Logs(ctx, id, r.readiness.logTail)
+	if err != nil {
+		logs = "(logs unavailable: " + err.Error() + ")"
+	}
+	logs = strings.TrimSpace(logs)
+	if logs == "" {
+		return notReadyError{fmt.Sprintf("replica %s: %s", shortID(id), reason)}
+	}
+	return notReadyError{fmt.Sprintf("replica %s: %s; last logs:\n%s", shortID(id), reason, logs)}
+}
+
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
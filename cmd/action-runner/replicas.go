@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/ilya2309548/EventPulse/internal/events"
+)
+
+// handleReplicas serves GET /replicas?service=app or ?label=tier=worker
+// (repeatable): the number of running replicas of a scale target, as counted
+// by scale actions. Without a selector the default service is counted.
+func (r *Runner) handleReplicas(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := req.URL.Query()
+	var t events.Target
+	t.Service = strings.TrimSpace(q.Get("service"))
+	for _, l := range q["label"] {
+		k, v, ok := strings.Cut(l, "=")
+		if !ok || k == "" {
+			http.Error(w, "label must be key=value", http.StatusBadRequest)
+			return
+		}
+		if t.Labels == nil {
+			t.Labels = map[string]string{}
+		}
+		t.Labels[k] = v
+	}
+	var ar events.ActionRequested
+	if t.Service != "" || len(t.Labels) > 0 {
+		ar.Target = &t
+	}
+	target := resolveTarget(ar)
+	list, err := r.listReplicas(req.Context(), target)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"target": target.name, "replicas": len(list)})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ilya2309548/EventPulse/internal/events"
+)
+
+func init() {
+	registerExecutor(func(r *Runner) Executor { return restartExecutor{r: r} })
+}
+
+// restartExecutor starts a stopped runner container by its compose service
+// name (e.g. "action-runner-a"), found by label com.docker.compose.service.
+type restartExecutor struct{ r *Runner }
+
+func (restartExecutor) Kind() string { return events.KindRestartRunner }
+
+func (restartExecutor) Params() []Param {
+	return []Param{{Name: "target_runner", Type: "string", Required: true, Description: "compose service name of the runner to start"}}
+}
+
+func (restartExecutor) Timeout() time.Duration { return 30 * time.Second }
+
+func (restartExecutor) Validate(req events.ActionRequested) error {
+	if strings.TrimSpace(req.TargetRunner) == "" {
+		return errors.New("target_runner is required")
+	}
+	return nil
+}
+
+// LeaseKey serializes restarts of the same runner.
+func (restartExecutor) LeaseKey(req events.ActionRequested) string {
+	return "runner:" + strings.TrimSpace(req.TargetRunner)
+}
+
+func (e restartExecutor) Execute(ctx context.Context, req events.ActionRequested) (map[string]string, error) {
+	svc := strings.TrimSpace(req.TargetRunner)
+
+	// Find container by compose service label
+	list, err := e.r.docker.List(ctx, map[string]string{"com.docker.compose.service": svc}, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("container for service %s not found", svc)
+	}
+	c := list[0]
+	out := map[string]string{"container": c.ID}
+
+	// If already running, treat as success
+	if c.Running {
+		out["started"] = "false"
+		return out, nil
+	}
+	if err := e.r.docker.Start(ctx, c.ID); err != nil {
+		return nil, err
+	}
+	out["started"] = "true"
+
+	// Best-effort readiness probe against runner health
+	client := &http.Client{Timeout: 2 * time.Second}
+	url := fmt.Sprintf("http://%s:8092/health", svc)
+	deadline := time.Now().Add(15 * time.Second)
+	for time.Now().Before(deadline) {
+		hreq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(hreq)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == 200 {
+				out["healthy"] = "true"
+				return out, nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+	// If health didn't come up within deadline, still return success (container started)
+	out["healthy"] = "false"
+	return out, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ilya2309548/EventPulse/internal/containers"
+	"github.com/ilya2309548/EventPulse/internal/events"
+)
+
+func runnerContainer(svc string, running bool) containers.Container {
+	return containers.Container{
+		Name: "eventpulse-" + svc + "-1", Labels: map[string]string{"com.docker.compose.service": svc},
+		Running: running, Created: time.Now().Add(-time.Hour).UTC(),
+	}
+}
+
+func TestRestartExecute(t *testing.T) {
+	tests := []struct {
+		name    string
+		initial []containers.Container
+		failOp  string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:    "already running",
+			initial: []containers.Container{runnerContainer("action-runner-a", true)},
+			want:    map[string]string{"started": "false"},
+		},
+		{
+			name:    "not found",
+			initial: []containers.Container{runnerContainer("action-runner-b", false)},
+			wantErr: "container for service action-runner-a not found",
+		},
+		{
+			name:    "start fails",
+			initial: []containers.Container{runnerContainer("action-runner-a", false)},
+			failOp:  "start",
+			wantErr: "engine unavailable",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeEngine(tt.initial...)
+			if tt.failOp != "" {
+				f.err[tt.failOp] = containers.ErrUnavailable
+			}
+			req := events.NewActionRequested("fp", events.KindRestartRunner)
+			req.TargetRunner = "action-runner-a"
+			out, err := restartExecutor{r: &Runner{docker: f}}.Execute(context.Background(), req)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for k, v := range tt.want {
+				if out[k] != v {
+					t.Errorf("output %s = %q, want %q", k, out[k], v)
+				}
+			}
+		})
+	}
+}
+
+// A stopped runner is started; the health wait that follows ends with the
+// action's context.
+func TestRestartStartsStoppedRunner(t *testing.T) {
+	f := newFakeEngine(runnerContainer("action-runner-a", false))
+	req := events.NewActionRequested("fp", events.KindRestartRunner)
+	req.TargetRunner = "action-runner-a"
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := restartExecutor{r: &Runner{docker: f}}.Execute(ctx, req)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want the context deadline", err)
+	}
+	list, _ := f.List(context.Background(), map[string]string{"com.docker.compose.service": "action-runner-a"}, false)
+	if len(list) != 1 {
+		t.Fatalf("running runner containers = %d, want 1", len(list))
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ilya2309548/EventPulse/internal/containers"
+	"github.com/ilya2309548/EventPulse/internal/events"
+)
+
+// RetryPolicy controls how failed attempts of an action kind are retried.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration // delay after the first failed attempt, doubled after each one
+	MaxBackoff  time.Duration
+	// Retryable reports whether an attempt that failed with err is worth
+	// repeating; nil means isTransient.
+	Retryable func(err error) bool
+}
+
+// Retrier is implemented by executors that need a retry policy other than
+// defaultRetryPolicy.
+type Retrier interface {
+	RetryPolicy() RetryPolicy
+}
+
+var defaultRetryPolicy = RetryPolicy{MaxAttempts: 3, Backoff: 2 * time.Second, MaxBackoff: 30 * time.Second}
+
+// isTransient classifies errors that may go away on their own: the Docker
+// engine being unreachable or failing with a server error, and an attempt
+// running into its timeout. Missing containers, conflicts and invalid
+// requests are permanent.
+func isTransient(err error) bool {
+	return containers.IsUnavailable(err) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// retryPolicy returns the policy of ex, overridden by RETRY_<KIND>_MAX_ATTEMPTS,
+// RETRY_<KIND>_BACKOFF and RETRY_<KIND>_MAX_BACKOFF (e.g. RETRY_SCALE_DOCKER_BACKOFF=5s).
+func retryPolicy(ex Executor) RetryPolicy {
+	p := defaultRetryPolicy
+	if rt, ok := ex.(Retrier); ok {
+		p = rt.RetryPolicy()
+	}
+	prefix := "RETRY_" + strings.ToUpper(ex.Kind()) + "_"
+	if v := strings.TrimSpace(os.Getenv(prefix + "MAX_ATTEMPTS")); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			p.MaxAttempts = n
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv(prefix + "BACKOFF")); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			p.Backoff = d
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv(prefix + "MAX_BACKOFF")); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			p.MaxBackoff = d
+		}
+	}
+	if p.MaxAttempts < 1 {
+		p.MaxAttempts = 1
+	}
+	if p.Retryable == nil {
+		p.Retryable = isTransient
+	}
+	return p
+}
+
+// delay returns the backoff after the given failed attempt.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// exhausted returns an error if no attempt is left after used ones.
+func (p RetryPolicy) exhausted(used int) error {
+	if used >= p.MaxAttempts {
+		return fmt.Errorf("attempts exhausted: %d of %d used", used, p.MaxAttempts)
+	}
+	return nil
+}
+
+// actionAttempts returns the number of attempts already recorded for an
+// action, so that a resumed action continues counting where it stopped.
+func (r *Runner) actionAttempts(ctx context.Context, actionID string) (int, error) {
+	var n int
+	err := r.db.QueryRowContext(ctx, `SELECT attempts FROM action_exec WHERE action_id=$1`, actionID).Scan(&n)
+	return n, err
+}
+
+func (r *Runner) startAttempt(ctx context.Context, actionID string, attempt int) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	if _, err := r.db.ExecContext(ctx, `UPDATE action_exec SET attempts=$1, status='running', updated_at=$2 WHERE action_id=$3`,
+		attempt, now, actionID); err != nil {
+		return err
+	}
+	return appendHistory(r.db, actionID, "running", fmt.Sprintf("attempt %d", attempt), now)
+}
+
+// attemptTimeout is the executor's timeout, or timeout_seconds from the
+// request capped by maxTimeout.
+func (r *Runner) attemptTimeout(ex Executor, req events.ActionRequested) time.Duration {
+	if req.TimeoutSeconds <= 0 {
+		return ex.Timeout()
+	}
+	d := time.Duration(req.TimeoutSeconds) * time.Second
+	if r.maxTimeout > 0 && d > r.maxTimeout {
+		d = r.maxTimeout
+	}
+	return d
+}
+
+// reportRetry records the failed attempt and writes action.retrying to the
+// outbox in one transaction.
+func (r *Runner) reportRetry(req events.ActionRequested, attempt, max int, attemptErr error, retryAt time.Time) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.Exec(`UPDATE action_exec SET status='retrying', error=$1, updated_at=$2 WHERE action_id=$3`,
+		attemptErr.Error(), now, req.ActionID); err != nil {
+		return err
+	}
+	if err := appendHistory(tx, req.ActionID, "retrying", attemptErr.Error(), now); err != nil {
+		return err
+	}
+	pjson, _ := json.Marshal(events.NewActionRetrying(req, attempt, max, attemptErr, retryAt))
+	if err := writeOutbox(tx, events.TypeActionRetrying, string(pjson), now); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	r.relay.Notify()
+	return nil
+}
+
+// runAttempts executes req until an attempt succeeds, fails with an error the
+// policy does not retry, or the attempts are used up. Each attempt is bounded
+// by attemptTimeout. It returns the number of the last attempt.
+func (r *Runner) runAttempts(ctx context.Context, ex Executor, req events.ActionRequested, leaseKey string) (map[string]string, int, error) {
+	policy := retryPolicy(ex)
+	attempt, err := r.actionAttempts(ctx, req.ActionID)
+	if err != nil {
+		return nil, 0, err
+	}
+	// A redelivered action resumes its count; one that used up its attempts
+	// before the runner stopped is not executed again.
+	if err := policy.exhausted(attempt); err != nil {
+		return nil, attempt, err
+	}
+	for {
+		attempt++
+		if err := r.startAttempt(ctx, req.ActionID, attempt); err != nil {
+			return nil, attempt, err
+		}
+		actx, cancel := context.WithTimeout(ctx, r.attemptTimeout(ex, req))
+		out, err := ex.Execute(actx, req)
+		cancel()
+		if err == nil {
+			return out, attempt, nil
+		}
+		if attempt >= policy.MaxAttempts || ctx.Err() != nil || !policy.Retryable(err) {
+			return nil, attempt, err
+		}
+		// Give up the remaining attempts if a newer desired state for the
+		// same target is already waiting.
+		if leaseKey != "" {
+			newer, nerr := r.newerAction(ctx, leaseKey, req)
+			if nerr != nil {
+				return nil, attempt, nerr
+			}
+			if newer != "" {
+				return nil, attempt, errSuperseded{by: newer}
+			}
+		}
+		wait := policy.delay(attempt)
+		log.Printf("action %s: attempt %d/%d failed, retrying in %s: %v", req.ActionID, attempt, policy.MaxAttempts, wait, err)
+		if rerr := r.reportRetry(req, attempt, policy.MaxAttempts, err, time.Now().Add(wait)); rerr != nil {
+			return nil, attempt, rerr
+		}
+		select {
+		case <-ctx.Done():
+			return nil, attempt, err
+		case <-time.After(wait):
+		}
+	}
+}
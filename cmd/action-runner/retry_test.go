@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ilya2309548/EventPulse/internal/containers"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{"first attempt", RetryPolicy{Backoff: 2 * time.Second, MaxBackoff: 30 * time.Second}, 1, 2 * time.Second},
+		{"doubles", RetryPolicy{Backoff: 2 * time.Second, MaxBackoff: 30 * time.Second}, 3, 8 * time.Second},
+		{"capped", RetryPolicy{Backoff: 2 * time.Second, MaxBackoff: 30 * time.Second}, 5, 30 * time.Second},
+		{"capped far out", RetryPolicy{Backoff: 2 * time.Second, MaxBackoff: 30 * time.Second}, 200, 30 * time.Second},
+		{"backoff above cap", RetryPolicy{Backoff: time.Minute, MaxBackoff: 30 * time.Second}, 1, 30 * time.Second},
+		{"no cap keeps the base", RetryPolicy{Backoff: time.Second}, 4, time.Second},
+		{"zero backoff", RetryPolicy{MaxBackoff: 30 * time.Second}, 3, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.delay(tt.attempt); got != tt.want {
+				t.Errorf("delay(%d) = %s, want %s", tt.attempt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyExhausted(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3}
+	tests := []struct {
+		used int
+		want bool
+	}{
+		{0, false},
+		{2, false},
+		{3, true},
+		{5, true},
+	}
+	for _, tt := range tests {
+		if err := p.exhausted(tt.used); (err != nil) != tt.want {
+			t.Errorf("exhausted(%d) = %v, want exhausted=%v", tt.used, err, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicyFromEnv(t *testing.T) {
+	t.Setenv("RETRY_SCALE_DOCKER_MAX_ATTEMPTS", "5")
+	t.Setenv("RETRY_SCALE_DOCKER_BACKOFF", "5s")
+	t.Setenv("RETRY_SCALE_DOCKER_MAX_BACKOFF", "bogus")
+	p := retryPolicy(scaleExecutor{})
+	if p.MaxAttempts != 5 || p.Backoff != 5*time.Second || p.MaxBackoff != (scaleExecutor{}).RetryPolicy().MaxBackoff {
+		t.Errorf("policy = %+v", p)
+	}
+	if p.Retryable == nil {
+		t.Error("Retryable not defaulted")
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{fmt.Errorf("list: %w", containers.ErrUnavailable), true},
+		{context.DeadlineExceeded, true},
+		{containers.ErrNotFound, false},
+		{containers.ErrConflict, false},
+		{errors.New("no template for app"), false},
+	}
+	for _, tt := range tests {
+		if got := isTransient(tt.err); got != tt.want {
+			t.Errorf("isTransient(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
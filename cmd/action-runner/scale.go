@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ilya2309548/EventPulse/internal/containers"
+	"github.com/ilya2309548/EventPulse/internal/events"
+)
+
+func init() {
+	registerExecutor(func(r *Runner) Executor { return scaleExecutor{r: r} })
+}
+
+// scaleExecutor converges the number of app replicas to desired_replicas.
+type scaleExecutor struct{ r *Runner }
+
+func (scaleExecutor) Kind() string { return events.KindScaleDocker }
+
+func (scaleExecutor) Params() []Param {
+	return []Param{
+		{Name: "desired_replicas", Type: "int", Required: true, Description: "number of replicas to converge to"},
+		{Name: "service", Type: "string", Description: "value of the service label of the containers to scale (default app)"},
+	}
+}
+
+// Timeout covers container operations plus waiting for new replicas or
+// draining removed ones.
+func (e scaleExecutor) Timeout() time.Duration {
+	return 60*time.Second + e.r.readiness.timeout + e.r.draining.period + e.r.draining.grace
+}
+
+func (scaleExecutor) Validate(req events.ActionRequested) error {
+	if req.DesiredReplicas < 1 {
+		return fmt.Errorf("desired_replicas must be >= 1, got %d", req.DesiredReplicas)
+	}
+	if req.Target != nil {
+		return req.Target.Validate(req.Type)
+	}
+	return nil
+}
+
+// RetryPolicy retries engine errors and replicas that did not become ready;
+// each attempt starts over from the current replica count.
+func (scaleExecutor) RetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     5 * time.Second,
+		MaxBackoff:  time.Minute,
+		Retryable:   func(err error) bool { return isTransient(err) || isNotReady(err) },
+	}
+}
+
+// LeaseKey serializes scale actions per target.
+func (scaleExecutor) LeaseKey(req events.ActionRequested) string {
+	return "scale:" + resolveTarget(req).name
+}
+
+// Execute converges the target. Replicas created by a failed or cancelled
+// attempt are removed again, so the next attempt starts from the previous
+// state.
+func (e scaleExecutor) Execute(ctx context.Context, req events.ActionRequested) (_ map[string]string, err error) {
+	r := e.r
+	target := resolveTarget(req)
+	desired := target.clamp(req.DesiredReplicas)
+	if desired != req.DesiredReplicas {
+		log.Printf("scale %s: desired_replicas %d clamped to %d", target.name, req.DesiredReplicas, desired)
+	}
+	cur, err := r.listReplicas(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	var created []string
+	ready := false
+	defer func() {
+		if err == nil || ready {
+			return
+		}
+		for _, id := range created {
+			if rmErr := r.removeContainer(context.Background(), id); rmErr != nil {
+				log.Printf("remove replica %s of failed attempt: %v", id, rmErr)
+			}
+		}
+	}()
+	removed := 0
+	if len(cur) < desired {
+		spec, err := r.replicaTemplate(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+		missing := desired - len(cur)
+		for i := 0; i < missing; i++ {
+			id, err := r.createReplica(ctx, target, spec)
+			if err != nil {
+				return nil, fmt.Errorf("create replica: %w", err)
+			}
+			created = append(created, id)
+		}
+		// Replicas start in parallel; wait until each one is ready. A replica
+		// that never becomes ready fails the attempt.
+		start := time.Now()
+		for _, id := range created {
+			if err := r.waitReady(ctx, id); err != nil {
+				return nil, err
+			}
+		}
+		ready = true
+		log.Printf("scale: %d new replica(s) ready after %s", len(created), time.Since(start).Round(time.Millisecond))
+	} else if len(cur) > desired {
+		// Only replicas created by the runner are removed, newest first; the
+		// baseline containers stay, they are the template of later scale-ups
+		var owned []containers.Container
+		for _, c := range cur {
+			if c.Labels[labelManagedBy] == managedByRunner {
+				owned = append(owned, c)
+			}
+		}
+		if baseline := len(cur) - len(owned); desired < baseline {
+			return nil, fmt.Errorf("cannot scale %s to %d: %d baseline container(s) not created by the runner are never removed", target.name, desired, baseline)
+		}
+		sort.Slice(owned, func(i, j int) bool { return owned[i].Created.After(owned[j].Created) })
+		var ids []string
+		for _, c := range owned[:len(cur)-desired] {
+			ids = append(ids, c.ID)
+		}
+		removed = r.drainAll(ctx, ids)
+	}
+	final, err := r.listReplicas(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	if len(final) != desired {
+		return nil, fmt.Errorf("replica convergence failed: have=%d desired=%d", len(final), desired)
+	}
+	return map[string]string{
+		"target":   target.name,
+		"desired":  strconv.Itoa(desired),
+		"replicas": strconv.Itoa(len(final)),
+		"created":  strconv.Itoa(len(created)),
+		"removed":  strconv.Itoa(removed),
+	}, nil
+}
+
+func (r *Runner) removeContainer(ctx context.Context, id string) error {
+	err := r.docker.Remove(ctx, id, true)
+	if containers.IsNotFound(err) {
+		return nil // already gone
+	}
+	return err
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ilya2309548/EventPulse/internal/containers"
+	"github.com/ilya2309548/EventPulse/internal/events"
+)
+
+// newTestRunner returns a Runner on f whose replicas answer drain requests on
+// a local server; drains counts the requests.
+func newTestRunner(t *testing.T, f *fakeEngine) (*Runner, *atomic.Int32) {
+	t.Helper()
+	drains := &atomic.Int32{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/drain" {
+			drains.Add(1)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(srv.URL, "http://"))
+	p, _ := strconv.Atoi(port)
+	f.ipAddress = "127.0.0.1"
+	r := &Runner{
+		docker:    f,
+		readiness: readiness{timeout: time.Second, interval: 10 * time.Millisecond, path: "/healthz", port: p, logTail: 5},
+		draining:  draining{period: 20 * time.Millisecond, path: "/drain"},
+	}
+	return r, drains
+}
+
+// baseline is a compose-created app container, the template of new replicas.
+func baseline(name string, age time.Duration) containers.Container {
+	labels := map[string]string{labelService: defaultService, "com.docker.compose.service": defaultService}
+	return containers.Container{
+		Name: name, Image: "eventpulse-app:latest", Labels: labels, Running: true, IPAddress: "127.0.0.1",
+		Created: time.Now().Add(-age).UTC(),
+		Spec:    containers.Spec{Image: "eventpulse-app:latest", Labels: labels},
+	}
+}
+
+// managed is a replica created by the runner.
+func managed(name string, age time.Duration) containers.Container {
+	c := baseline(name, age)
+	c.Labels = map[string]string{labelService: defaultService, labelManagedBy: managedByRunner}
+	c.Spec.Labels = c.Labels
+	return c
+}
+
+func TestScaleExecute(t *testing.T) {
+	tests := []struct {
+		name     string
+		initial  []containers.Container
+		desired  int
+		failOp   string
+		health   string
+		wantErr  string
+		want     map[string]string
+		wantLeft []string // names of the containers left running, besides new replicas
+		wantNew  int      // replicas created by the runner and left running
+	}{
+		{
+			name:     "scale up from baseline",
+			initial:  []containers.Container{baseline("app-1", time.Hour)},
+			desired:  3,
+			want:     map[string]string{"target": "app", "desired": "3", "replicas": "3", "created": "2", "removed": "0"},
+			wantLeft: []string{"app-1"},
+			wantNew:  2,
+		},
+		{
+			name:     "already converged",
+			initial:  []containers.Container{baseline("app-1", time.Hour), managed("app-replica-1", time.Minute)},
+			desired:  2,
+			want:     map[string]string{"target": "app", "desired": "2", "replicas": "2", "created": "0", "removed": "0"},
+			wantLeft: []string{"app-1", "app-replica-1"},
+		},
+		{
+			name:     "scale down removes runner replicas newest first",
+			initial:  []containers.Container{baseline("app-1", time.Hour), managed("app-replica-1", 2*time.Minute), managed("app-replica-2", time.Minute)},
+			desired:  2,
+			want:     map[string]string{"target": "app", "desired": "2", "replicas": "2", "created": "0", "removed": "1"},
+			wantLeft: []string{"app-1", "app-replica-1"},
+		},
+		{
+			name:     "scale down never removes baseline containers",
+			initial:  []containers.Container{baseline("app-1", time.Hour), baseline("app-2", time.Hour), managed("app-replica-1", time.Minute)},
+			desired:  1,
+			wantErr:  "2 baseline container(s) not created by the runner are never removed",
+			wantLeft: []string{"app-1", "app-2", "app-replica-1"},
+		},
+		{
+			name:     "scale down to the baseline",
+			initial:  []containers.Container{baseline("app-1", time.Hour), managed("app-replica-1", 2*time.Minute), managed("app-replica-2", time.Minute)},
+			desired:  1,
+			want:     map[string]string{"target": "app", "desired": "1", "replicas": "1", "created": "0", "removed": "2"},
+			wantLeft: []string{"app-1"},
+		},
+		{
+			name:    "no template",
+			desired: 1,
+			wantErr: "no template for app",
+		},
+		{
+			name:     "start failure leaves nothing behind",
+			initial:  []containers.Container{baseline("app-1", time.Hour)},
+			desired:  2,
+			failOp:   "start",
+			wantErr:  "create replica",
+			wantLeft: []string{"app-1"},
+		},
+		{
+			name:     "unready replica is removed again",
+			initial:  []containers.Container{baseline("app-1", time.Hour)},
+			desired:  2,
+			health:   "unhealthy",
+			wantErr:  "healthcheck reports unhealthy",
+			wantLeft: []string{"app-1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeEngine(tt.initial...)
+			if tt.failOp != "" {
+				f.err[tt.failOp] = containers.ErrUnavailable
+			}
+			f.health = tt.health
+			r, _ := newTestRunner(t, f)
+			req := events.NewActionRequested("fp", events.KindScaleDocker)
+			req.DesiredReplicas = tt.desired
+			out, err := scaleExecutor{r: r}.Execute(context.Background(), req)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for k, v := range tt.want {
+				if out[k] != v {
+					t.Errorf("output %s = %q, want %q", k, out[k], v)
+				}
+			}
+			left, _ := f.List(context.Background(), nil, true)
+			names, created := map[string]bool{}, 0
+			for _, c := range left {
+				if strings.Contains(c.Name, "-replica-") && c.Created.After(time.Now().Add(-time.Second)) {
+					created++
+					if c.Labels[labelManagedBy] != managedByRunner || c.Labels[labelService] != defaultService {
+						t.Errorf("replica %s labels = %v", c.Name, c.Labels)
+					}
+					if _, ok := c.Labels["com.docker.compose.service"]; ok {
+						t.Errorf("replica %s inherited compose labels", c.Name)
+					}
+					continue
+				}
+				names[c.Name] = true
+			}
+			if created != tt.wantNew {
+				t.Errorf("new replicas = %d, want %d", created, tt.wantNew)
+			}
+			if len(names) != len(tt.wantLeft) {
+				t.Errorf("containers left = %v, want %v", names, tt.wantLeft)
+			}
+			for _, n := range tt.wantLeft {
+				if !names[n] {
+					t.Errorf("container %s was removed", n)
+				}
+			}
+		})
+	}
+}
+
+func TestScaleDrainsRemovedReplicas(t *testing.T) {
+	f := newFakeEngine(baseline("app-1", time.Hour), managed("app-replica-1", 2*time.Minute), managed("app-replica-2", time.Minute))
+	r, drains := newTestRunner(t, f)
+	req := events.NewActionRequested("fp", events.KindScaleDocker)
+	req.DesiredReplicas = 1
+	if _, err := (scaleExecutor{r: r}).Execute(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if n := drains.Load(); n != 2 {
+		t.Errorf("drain requests = %d, want 2", n)
+	}
+}
+
+func TestScaleUnavailableEngineIsTransient(t *testing.T) {
+	f := newFakeEngine(baseline("app-1", time.Hour))
+	f.err["list"] = containers.ErrUnavailable
+	r, _ := newTestRunner(t, f)
+	req := events.NewActionRequested("fp", events.KindScaleDocker)
+	req.DesiredReplicas = 2
+	_, err := scaleExecutor{r: r}.Execute(context.Background(), req)
+	if !errors.Is(err, containers.ErrUnavailable) || !isTransient(err) {
+		t.Fatalf("err = %v, want a transient unavailable error", err)
+	}
+}
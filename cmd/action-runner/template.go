@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ilya2309548/EventPulse/internal/containers"
+	"github.com/ilya2309548/EventPulse/internal/events"
+)
+
+const (
+	defaultService  = "app"
+	labelService    = "service"
+	labelManagedBy  = "managed-by"
+	managedByRunner = "action-runner"
+	// labelTemplate=true marks the container whose configuration replicas of
+	// its service are cloned from.
+	labelTemplate = "eventpulse.replica-template"
+)
+
+// loadTemplates reads a replica template file: a JSON object mapping service
+// names to container specs. Services listed there do not need a template container.
+func loadTemplates(path string) (map[string]containers.Spec, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t map[string]containers.Spec
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for svc, spec := range t {
+		if spec.Image == "" {
+			return nil, fmt.Errorf("%s: template %q has no image", path, svc)
+		}
+	}
+	return t, nil
+}
+
+func serviceLabels(service string) map[string]string {
+	return map[string]string{labelService: service}
+}
+
+// scaleTarget is the resolved target of a scale action.
+type scaleTarget struct {
+	// name identifies the target in logs, replica names and the template
+	// file: the service name, or the selector rendered as k=v,k=v.
+	name     string
+	selector map[string]string
+	min, max int
+}
+
+// resolveTarget picks the target of req: the event's target selector, else
+// the legacy service param, else service=app.
+func resolveTarget(req events.ActionRequested) scaleTarget {
+	t := scaleTarget{name: defaultService, selector: serviceLabels(defaultService)}
+	if v, ok := req.Params["service"].(string); ok && strings.TrimSpace(v) != "" {
+		t.name = strings.TrimSpace(v)
+		t.selector = serviceLabels(t.name)
+	}
+	if req.Target == nil {
+		return t
+	}
+	t.min, t.max = req.Target.MinReplicas, req.Target.MaxReplicas
+	if len(req.Target.Labels) == 0 {
+		t.name = req.Target.Service
+		t.selector = serviceLabels(t.name)
+		return t
+	}
+	t.selector = map[string]string{}
+	var parts []string
+	for k, v := range req.Target.Labels {
+		t.selector[k] = v
+		parts = append(parts, k+"="+v)
+	}
+	sort.Strings(parts)
+	t.name = strings.Join(parts, ",")
+	if req.Target.Service != "" {
+		t.selector[labelService] = req.Target.Service
+		t.name = req.Target.Service
+	}
+	return t
+}
+
+// clamp keeps n within the target's replica bounds.
+func (t scaleTarget) clamp(n int) int {
+	if t.min > 0 && n < t.min {
+		n = t.min
+	}
+	if t.max > 0 && n > t.max {
+		n = t.max
+	}
+	return n
+}
+
+func (r *Runner) listReplicas(ctx context.Context, t scaleTarget) ([]containers.Container, error) {
+	return r.docker.List(ctx, t.selector, false)
+}
+
+// replicaTemplate returns the spec new replicas of a target are created from:
+// the template file entry if there is one, otherwise the configuration of the
+// target's template container — the one labelled eventpulse.replica-template=true,
+// else the oldest matching container not created by the runner.
+func (r *Runner) replicaTemplate(ctx context.Context, t scaleTarget) (containers.Spec, error) {
+	if spec, ok := r.templates[t.name]; ok {
+		return spec, nil
+	}
+	list, err := r.docker.List(ctx, t.selector, true)
+	if err != nil {
+		return containers.Spec{}, err
+	}
+	var candidates []containers.Container
+	for _, c := range list {
+		if c.Labels[labelTemplate] == "true" {
+			candidates = []containers.Container{c}
+			break
+		}
+		if c.Labels[labelManagedBy] != managedByRunner {
+			candidates = append(candidates, c)
+		}
+	}
+	if len(candidates) == 0 {
+		return containers.Spec{}, fmt.Errorf("no template for %s: no baseline container and no template file entry", t.name)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Created.Before(candidates[j].Created) })
+	c, err := r.docker.Inspect(ctx, candidates[0].ID)
+	if err != nil {
+		return containers.Spec{}, err
+	}
+	spec := c.Spec
+	if spec.Image == "" {
+		spec.Image = c.Image
+	}
+	return spec, nil
+}
+
+// createReplica creates and starts a replica of the target from spec. Compose
+// labels are dropped so compose does not adopt the replica, and the template
+// marker is not inherited; the selector labels are always set so the replica
+// counts towards the target.
+func (r *Runner) createReplica(ctx context.Context, t scaleTarget, spec containers.Spec) (string, error) {
+	labels := map[string]string{}
+	for k, v := range spec.Labels {
+		if strings.HasPrefix(k, "com.docker.compose.") || k == labelTemplate {
+			continue
+		}
+		labels[k] = v
+	}
+	for k, v := range t.selector {
+		labels[k] = v
+	}
+	labels[labelManagedBy] = managedByRunner
+	spec.Labels = labels
+	spec.Name = fmt.Sprintf("%s-replica-%d", containerName(t.name), time.Now().UnixNano())
+	if r.dockerNetwork != "" {
+		spec.Network = r.dockerNetwork
+	}
+	id, err := r.docker.Create(ctx, spec)
+	if err != nil {
+		return "", err
+	}
+	if err := r.docker.Start(ctx, id); err != nil {
+		// Do not leave a created-but-stopped replica behind
+		_ = r.docker.Remove(ctx, id, true)
+		return "", err
+	}
+	return id, nil
+}
+
+// containerName maps a target name to characters allowed in container names.
+func containerName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.', r == '-':
+			return r
+		}
+		return '-'
+	}, s)
+}
@@ -1,15 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math"
 	"math/rand"
 	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/ilya2309548/EventPulse/internal/common"
@@ -58,11 +63,34 @@ func workHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "ok %dms workers=%d\n", ms, workers)
 }
 
+// draining is set by POST /drain or SIGTERM: /healthz starts failing so the
+// container turns unhealthy and Traefik stops routing to it, while requests
+// already in flight still complete.
+var draining atomic.Bool
+
 func healthHandler(w http.ResponseWriter, r *http.Request) {
+	if draining.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("draining"))
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte("ok"))
 }
 
+func drainHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !draining.Swap(true) {
+		log.Printf("draining")
+	}
+	w.WriteHeader(http.StatusAccepted)
+	_, _ = w.Write([]byte("draining"))
+}
+
 func main() {
 	service := os.Getenv("SERVICE")
 	if service == "" {
@@ -82,10 +110,28 @@ func main() {
 
 	http.HandleFunc("/work", workHandler)
 	http.HandleFunc("/healthz", healthHandler)
+	http.HandleFunc("/drain", drainHandler)
 
 	addr := ":8080"
+	srv := &http.Server{Addr: addr}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// On SIGTERM (docker stop) finish in-flight requests before exiting
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+		<-sig
+		draining.Store(true)
+		log.Printf("shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("shutdown: %v", err)
+		}
+	}()
 	log.Printf("listening on %s", addr)
-	if err := http.ListenAndServe(addr, nil); err != nil {
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatal(err)
 	}
+	<-done
 }
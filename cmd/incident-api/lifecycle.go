@@ -0,0 +1,192 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Timeline entry types written by the lifecycle endpoints. Status changes made
+// through resolve/reopen are recorded as status.changed with the actor.
+const (
+	eventAcknowledged = "incident.acknowledged"
+	eventAssigned     = "incident.assigned"
+	eventComment      = "incident.comment"
+)
+
+var (
+	errIncidentNotFound    = errors.New("incident not found")
+	errAlreadyAcknowledged = errors.New("incident already acknowledged")
+)
+
+// lifecycleRequest is the body of POST /incidents/{id}/{op}.
+type lifecycleRequest struct {
+	Actor    string `json:"actor"`
+	Assignee string `json:"assignee,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// lifecycleEntry is the incident_events payload of a lifecycle operation.
+type lifecycleEntry struct {
+	Actor    string `json:"actor"`
+	Assignee string `json:"assignee,omitempty"`
+	Previous string `json:"previous,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+func decodeLifecycle(r *http.Request, op string) (lifecycleRequest, error) {
+	var req lifecycleRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		return req, fmt.Errorf("invalid request: %w", err)
+	}
+	req.Actor = strings.TrimSpace(req.Actor)
+	req.Assignee = strings.TrimSpace(req.Assignee)
+	if req.Actor == "" {
+		return req, errors.New("actor is required")
+	}
+	switch op {
+	case "assign":
+		if req.Assignee == "" {
+			return req, errors.New("assignee is required")
+		}
+	case "comment":
+		if strings.TrimSpace(req.Comment) == "" {
+			return req, errors.New("comment is required")
+		}
+	}
+	return req, nil
+}
+
+// applyLifecycle performs op on an incident inside tx.
+func applyLifecycle(tx *sql.Tx, incidentID, op string, req lifecycleRequest, now string) error {
+	var ackBy, assignee string
+	err := tx.QueryRow(`SELECT COALESCE(acknowledged_by,''), COALESCE(assignee,'') FROM incidents WHERE incident_id=$1 FOR UPDATE`, incidentID).
+		Scan(&ackBy, &assignee)
+	if err == sql.ErrNoRows {
+		return errIncidentNotFound
+	}
+	if err != nil {
+		return err
+	}
+	entry := lifecycleEntry{Actor: req.Actor, Comment: req.Comment}
+	switch op {
+	case "acknowledge":
+		if ackBy != "" {
+			return fmt.Errorf("%w by %s", errAlreadyAcknowledged, ackBy)
+		}
+		if _, err := tx.Exec(`UPDATE incidents SET acknowledged_by=$1, acknowledged_at=$2, updated_at=$2 WHERE incident_id=$3`, req.Actor, now, incidentID); err != nil {
+			return err
+		}
+		return appendLifecycleEvent(tx, incidentID, eventAcknowledged, entry, now)
+	case "assign":
+		if _, err := tx.Exec(`UPDATE incidents SET assignee=$1, updated_at=$2 WHERE incident_id=$3`, req.Assignee, now, incidentID); err != nil {
+			return err
+		}
+		entry.Assignee, entry.Previous = req.Assignee, assignee
+		return appendLifecycleEvent(tx, incidentID, eventAssigned, entry, now)
+	case "comment":
+		if _, err := tx.Exec(`UPDATE incidents SET updated_at=$1 WHERE incident_id=$2`, now, incidentID); err != nil {
+			return err
+		}
+		return appendLifecycleEvent(tx, incidentID, eventComment, entry, now)
+	case "resolve", "reopen":
+		to := statusResolved
+		if op == "reopen" {
+			to = statusOpen
+		}
+		reason := req.Reason
+		if reason == "" {
+			reason = "manual " + op
+		}
+		changed, err := transitionIncident(tx, incidentID, to, reason, req.Actor, now)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			return fmt.Errorf("%w: incident is already %s", errIllegalTransition, to)
+		}
+		if req.Comment != "" {
+			return appendLifecycleEvent(tx, incidentID, eventComment, entry, now)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown operation %q", op)
+}
+
+func appendLifecycleEvent(tx *sql.Tx, incidentID, typ string, entry lifecycleEntry, now string) error {
+	payload, _ := json.Marshal(entry)
+	return appendIncidentEvent(tx, incidentID, typ, payload, now)
+}
+
+// handleIncident serves GET /incidents/{id} and
+// POST /incidents/{id}/acknowledge|assign|comment|resolve|reopen.
+func (a *API) handleIncident(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/incidents/"), "/")
+	parts := strings.Split(rest, "/")
+	if parts[0] == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+	switch len(parts) {
+	case 1:
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		a.getIncident(w, r, parts[0])
+	case 2:
+		switch parts[1] {
+		case "acknowledge", "assign", "comment", "resolve", "reopen":
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		a.updateIncident(w, r, parts[0], parts[1])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (a *API) updateIncident(w http.ResponseWriter, r *http.Request, id, op string) {
+	req, err := decodeLifecycle(r, op)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	tx, err := a.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	if err := applyLifecycle(tx, id, op, req, now); err != nil {
+		switch {
+		case errors.Is(err, errIncidentNotFound):
+			http.NotFound(w, r)
+		case errors.Is(err, errIllegalTransition), errors.Is(err, errAlreadyAcknowledged):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	a.getIncident(w, r, id)
+}
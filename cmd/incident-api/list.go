@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+// incidentQuery is a parsed GET /incidents request.
+type incidentQuery struct {
+	statuses      []string
+	alertFP       string
+	labels        map[string]string
+	createdAfter  string
+	createdBefore string
+	updatedAfter  string
+	updatedBefore string
+	text          string
+	sortField     string // created_at or updated_at
+	desc          bool
+	limit         int
+	cursor        *listCursor
+}
+
+// listCursor points after the last item of a page. It is handed out as opaque
+// base64 and only valid for the sort order it was issued with.
+type listCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+func (c listCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*listCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var c listCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return &c, nil
+}
+
+// parseIncidentQuery reads the list filters:
+//
+//	status=open,mitigating   alert_fp=...   label=severity=critical (repeatable)
+//	created_after, created_before, updated_after, updated_before (RFC3339)
+//	q=free text   sort=[-]created_at|[-]updated_at   limit=1..500   cursor=...
+func parseIncidentQuery(r *http.Request) (incidentQuery, error) {
+	v := r.URL.Query()
+	q := incidentQuery{sortField: "created_at", desc: true, limit: defaultListLimit, labels: map[string]string{}}
+	for _, s := range v["status"] {
+		for _, st := range strings.Split(s, ",") {
+			st = strings.TrimSpace(st)
+			if st == "" {
+				continue
+			}
+			if _, ok := transitions[st]; !ok {
+				return q, fmt.Errorf("unknown status %q", st)
+			}
+			q.statuses = append(q.statuses, st)
+		}
+	}
+	q.alertFP = strings.TrimSpace(v.Get("alert_fp"))
+	for _, l := range v["label"] {
+		k, val, ok := strings.Cut(l, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return q, fmt.Errorf("invalid label selector %q, want name=value", l)
+		}
+		q.labels[strings.TrimSpace(k)] = strings.TrimSpace(val)
+	}
+	for _, p := range []struct {
+		name string
+		dst  *string
+	}{
+		{"created_after", &q.createdAfter},
+		{"created_before", &q.createdBefore},
+		{"updated_after", &q.updatedAfter},
+		{"updated_before", &q.updatedBefore},
+	} {
+		s := strings.TrimSpace(v.Get(p.name))
+		if s == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return q, fmt.Errorf("invalid %s: %v", p.name, err)
+		}
+		// Timestamps are stored as UTC RFC3339 text, which sorts chronologically.
+		*p.dst = t.UTC().Format(time.RFC3339)
+	}
+	q.text = strings.TrimSpace(v.Get("q"))
+	if s := strings.TrimSpace(v.Get("sort")); s != "" {
+		q.desc = strings.HasPrefix(s, "-")
+		q.sortField = strings.TrimPrefix(s, "-")
+		if q.sortField != "created_at" && q.sortField != "updated_at" {
+			return q, fmt.Errorf("invalid sort %q, want [-]created_at or [-]updated_at", s)
+		}
+	}
+	if s := strings.TrimSpace(v.Get("limit")); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxListLimit {
+			return q, fmt.Errorf("invalid limit %q, want 1..%d", s, maxListLimit)
+		}
+		q.limit = n
+	}
+	if s := strings.TrimSpace(v.Get("cursor")); s != "" {
+		c, err := decodeCursor(s)
+		if err != nil {
+			return q, err
+		}
+		if c.Sort != q.sortKey() {
+			return q, errors.New("cursor was issued for a different sort order")
+		}
+		q.cursor = c
+	}
+	return q, nil
+}
+
+func (q incidentQuery) sortKey() string {
+	if q.desc {
+		return "-" + q.sortField
+	}
+	return q.sortField
+}
+
+// where builds the filter clause shared by the page and the total count.
+func (q incidentQuery) where() (string, []any) {
+	var conds []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if len(q.statuses) > 0 {
+		ph := make([]string, len(q.statuses))
+		for i, st := range q.statuses {
+			ph[i] = arg(st)
+		}
+		conds = append(conds, "status IN ("+strings.Join(ph, ",")+")")
+	}
+	if q.alertFP != "" {
+		conds = append(conds, "alert_fp = "+arg(q.alertFP))
+	}
+	if len(q.labels) > 0 {
+		b, _ := json.Marshal(q.labels)
+		conds = append(conds, "labels @> "+arg(string(b))+"::jsonb")
+	}
+	if q.createdAfter != "" {
+		conds = append(conds, "created_at >= "+arg(q.createdAfter))
+	}
+	if q.createdBefore != "" {
+		conds = append(conds, "created_at < "+arg(q.createdBefore))
+	}
+	if q.updatedAfter != "" {
+		conds = append(conds, "updated_at >= "+arg(q.updatedAfter))
+	}
+	if q.updatedBefore != "" {
+		conds = append(conds, "updated_at < "+arg(q.updatedBefore))
+	}
+	if q.text != "" {
+		p := arg("%" + likeEscaper.Replace(q.text) + "%")
+		conds = append(conds, fmt.Sprintf("(incident_id ILIKE %[1]s OR alert_fp ILIKE %[1]s OR COALESCE(assignee,'') ILIKE %[1]s OR labels::text ILIKE %[1]s)", p))
+	}
+	if len(conds) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+type incidentItem struct {
+	IncidentID string            `json:"incident_id"`
+	AlertFP    string            `json:"alert_fp"`
+	Status     string            `json:"status"`
+	Labels     map[string]string `json:"labels"`
+	Assignee   string            `json:"assignee,omitempty"`
+	CreatedAt  string            `json:"created_at"`
+	UpdatedAt  string            `json:"updated_at"`
+}
+
+type incidentPage struct {
+	Items      []incidentItem `json:"items"`
+	Total      int            `json:"total"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+func (a *API) listIncidents(w http.ResponseWriter, r *http.Request) {
+	q, err := parseIncidentQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	where, args := q.where()
+	page := incidentPage{Items: []incidentItem{}}
+	if err := a.db.QueryRowContext(r.Context(), `SELECT COUNT(*) FROM incidents`+where, args...).Scan(&page.Total); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Keyset pagination on (sort field, id); id breaks ties between equal timestamps.
+	cmp, order := ">", "ASC"
+	if q.desc {
+		cmp, order = "<", "DESC"
+	}
+	if q.cursor != nil {
+		args = append(args, q.cursor.Value, q.cursor.ID)
+		cond := fmt.Sprintf("(%s, id) %s ($%d, $%d)", q.sortField, cmp, len(args)-1, len(args))
+		if where == "" {
+			where = " WHERE " + cond
+		} else {
+			where += " AND " + cond
+		}
+	}
+	query := fmt.Sprintf(`SELECT id, incident_id, COALESCE(alert_fp,''), status, labels, COALESCE(assignee,''), created_at, updated_at
+		FROM incidents%s ORDER BY %s %s, id %s LIMIT %d`, where, q.sortField, order, order, q.limit+1)
+	rows, err := a.db.QueryContext(r.Context(), query, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	var lastID int64
+	var lastValue string
+	for rows.Next() {
+		var (
+			id     int64
+			it     incidentItem
+			labels []byte
+		)
+		if err := rows.Scan(&id, &it.IncidentID, &it.AlertFP, &it.Status, &labels, &it.Assignee, &it.CreatedAt, &it.UpdatedAt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(page.Items) == q.limit {
+			page.NextCursor = listCursor{Sort: q.sortKey(), Value: lastValue, ID: lastID}.encode()
+			break
+		}
+		_ = json.Unmarshal(labels, &it.Labels)
+		page.Items = append(page.Items, it)
+		lastID = id
+		lastValue = it.CreatedAt
+		if q.sortField == "updated_at" {
+			lastValue = it.UpdatedAt
+		}
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseIncidentQuery(t *testing.T) {
+	cursor := listCursor{Sort: "-created_at", Value: "2024-05-01T10:00:00Z", ID: 42}.encode()
+	tests := []struct {
+		name    string
+		query   string
+		want    incidentQuery
+		wantErr string
+	}{
+		{
+			name:  "defaults",
+			query: "",
+			want:  incidentQuery{sortField: "created_at", desc: true, limit: defaultListLimit, labels: map[string]string{}},
+		},
+		{
+			name:  "filters",
+			query: "status=open,mitigating&status=failed&alert_fp=fp1&label=severity=critical&label=%20env%20=prod&q=%20cpu%20",
+			want: incidentQuery{
+				statuses: []string{"open", "mitigating", "failed"}, alertFP: "fp1",
+				labels: map[string]string{"severity": "critical", "env": "prod"}, text: "cpu",
+				sortField: "created_at", desc: true, limit: defaultListLimit,
+			},
+		},
+		{
+			name:  "time range is normalized to UTC",
+			query: "created_after=2024-05-01T12:00:00%2B02:00&updated_before=2024-05-02T00:00:00Z",
+			want: incidentQuery{
+				createdAfter: "2024-05-01T10:00:00Z", updatedBefore: "2024-05-02T00:00:00Z",
+				sortField: "created_at", desc: true, limit: defaultListLimit, labels: map[string]string{},
+			},
+		},
+		{
+			name:  "ascending sort and limit",
+			query: "sort=updated_at&limit=500",
+			want:  incidentQuery{sortField: "updated_at", limit: 500, labels: map[string]string{}},
+		},
+		{
+			name:  "cursor",
+			query: "cursor=" + cursor,
+			want: incidentQuery{sortField: "created_at", desc: true, limit: defaultListLimit, labels: map[string]string{},
+				cursor: &listCursor{Sort: "-created_at", Value: "2024-05-01T10:00:00Z", ID: 42}},
+		},
+		{name: "unknown status", query: "status=open,closed", wantErr: `unknown status "closed"`},
+		{name: "bad label", query: "label=severity", wantErr: "invalid label selector"},
+		{name: "empty label name", query: "label==x", wantErr: "invalid label selector"},
+		{name: "bad time", query: "created_before=yesterday", wantErr: "invalid created_before"},
+		{name: "bad sort", query: "sort=-status", wantErr: "invalid sort"},
+		{name: "zero limit", query: "limit=0", wantErr: "invalid limit"},
+		{name: "limit too large", query: "limit=501", wantErr: "invalid limit"},
+		{name: "garbage cursor", query: "cursor=%21%21", wantErr: "invalid cursor"},
+		{name: "cursor of another sort", query: "sort=updated_at&cursor=" + cursor, wantErr: "different sort order"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := parseIncidentQuery(httptest.NewRequest("GET", "/incidents?"+tt.query, nil))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(q, tt.want) {
+				t.Errorf("query = %+v\nwant    %+v", q, tt.want)
+			}
+		})
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	for _, c := range []listCursor{
+		{Sort: "created_at", Value: "2024-05-01T10:00:00Z", ID: 1},
+		{Sort: "-updated_at", Value: "", ID: 0},
+		{Sort: "-created_at", Value: "2024-05-01T10:00:00Z", ID: 1 << 40},
+	} {
+		s := c.encode()
+		if strings.ContainsAny(s, "+/=") {
+			t.Errorf("cursor %q is not URL safe", s)
+		}
+		got, err := decodeCursor(s)
+		if err != nil {
+			t.Fatalf("decode %q: %v", s, err)
+		}
+		if *got != c {
+			t.Errorf("round trip = %+v, want %+v", *got, c)
+		}
+	}
+	for _, bad := range []string{"!!", "bm90IGpzb24"} {
+		if _, err := decodeCursor(bad); err == nil {
+			t.Errorf("decodeCursor(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestIncidentQueryWhere(t *testing.T) {
+	q := incidentQuery{statuses: []string{"open", "failed"}, alertFP: "fp", text: "50%_off"}
+	where, args := q.where()
+	want := " WHERE status IN ($1,$2) AND alert_fp = $3 AND (incident_id ILIKE $4 OR alert_fp ILIKE $4 OR COALESCE(assignee,'') ILIKE $4 OR labels::text ILIKE $4)"
+	if where != want {
+		t.Errorf("where = %q\nwant    %q", where, want)
+	}
+	wantArgs := []any{"open", "failed", "fp", `%50\%\_off%`}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("args = %v, want %v", args, wantArgs)
+	}
+	if where, args := (incidentQuery{}).where(); where != "" || len(args) != 0 {
+		t.Errorf("empty query: where = %q, args = %v", where, args)
+	}
+}
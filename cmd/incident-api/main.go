@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -16,6 +17,10 @@ import (
 	kafka "github.com/segmentio/kafka-go"
 
 	"github.com/ilya2309548/EventPulse/internal/common"
+	"github.com/ilya2309548/EventPulse/internal/consumer"
+	"github.com/ilya2309548/EventPulse/internal/dlq"
+	"github.com/ilya2309548/EventPulse/internal/events"
+	"github.com/ilya2309548/EventPulse/internal/storage"
 )
 
 type API struct {
@@ -58,13 +63,28 @@ func migrate(db *sql.DB) error {
 			created_at TEXT NOT NULL,
 			updated_at TEXT NOT NULL
 		)`,
+		`ALTER TABLE incidents ADD COLUMN IF NOT EXISTS correlation_id TEXT`,
+		`ALTER TABLE actions ADD COLUMN IF NOT EXISTS correlation_id TEXT`,
+		`CREATE INDEX IF NOT EXISTS idx_actions_incident ON actions(incident_id)`,
+		`ALTER TABLE incidents ADD COLUMN IF NOT EXISTS acknowledged_by TEXT`,
+		`ALTER TABLE incidents ADD COLUMN IF NOT EXISTS acknowledged_at TEXT`,
+		`ALTER TABLE incidents ADD COLUMN IF NOT EXISTS assignee TEXT`,
+		`CREATE INDEX IF NOT EXISTS idx_incident_events_incident ON incident_events(incident_id)`,
+		`ALTER TABLE incidents ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}'`,
+		`CREATE INDEX IF NOT EXISTS idx_incidents_status ON incidents(status, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_incidents_created ON incidents(created_at, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_incidents_updated ON incidents(updated_at, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_incidents_labels ON incidents USING GIN (labels)`,
+		`ALTER TABLE actions ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE actions ADD COLUMN IF NOT EXISTS max_attempts INTEGER`,
+		`ALTER TABLE actions ADD COLUMN IF NOT EXISTS next_retry_at TEXT`,
 	}
 	for _, s := range stmts {
 		if _, err := db.Exec(s); err != nil {
 			return err
 		}
 	}
-	return nil
+	return dlq.Migrate(db)
 }
 
 func (a *API) handleHealth(w http.ResponseWriter, _ *http.Request) {
@@ -82,44 +102,16 @@ func (a *API) handleReady(w http.ResponseWriter, _ *http.Request) {
 	_, _ = w.Write([]byte("not-ready"))
 }
 
-func (a *API) listIncidents(w http.ResponseWriter, _ *http.Request) {
-	rows, err := a.db.Query(`SELECT incident_id, alert_fp, status, created_at, updated_at FROM incidents ORDER BY id DESC LIMIT 200`)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
-	type item struct {
-		IncidentID string `json:"incident_id"`
-		AlertFP    string `json:"alert_fp"`
-		Status     string `json:"status"`
-		CreatedAt  string `json:"created_at"`
-		UpdatedAt  string `json:"updated_at"`
-	}
-	var out []item
-	for rows.Next() {
-		var it item
-		if err := rows.Scan(&it.IncidentID, &it.AlertFP, &it.Status, &it.CreatedAt, &it.UpdatedAt); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		out = append(out, it)
-	}
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(out)
-}
-
-func (a *API) getIncident(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimPrefix(r.URL.Path, "/incidents/")
-	if id == "" {
-		http.Error(w, "missing id", http.StatusBadRequest)
-		return
-	}
+func (a *API) getIncident(w http.ResponseWriter, r *http.Request, id string) {
 	var (
 		incidentID, alertFP, status, createdAt, updatedAt string
+		ackBy, ackAt, assignee                            string
+		labels                                            json.RawMessage
 	)
-	err := a.db.QueryRow(`SELECT incident_id, alert_fp, status, created_at, updated_at FROM incidents WHERE incident_id=$1`, id).
-		Scan(&incidentID, &alertFP, &status, &createdAt, &updatedAt)
+	err := a.db.QueryRow(`SELECT incident_id, alert_fp, status, created_at, updated_at,
+		COALESCE(acknowledged_by,''), COALESCE(acknowledged_at,''), COALESCE(assignee,''), labels
+		FROM incidents WHERE incident_id=$1`, id).
+		Scan(&incidentID, &alertFP, &status, &createdAt, &updatedAt, &ackBy, &ackAt, &assignee, &labels)
 	if err == sql.ErrNoRows {
 		http.NotFound(w, r)
 		return
@@ -139,6 +131,9 @@ func (a *API) getIncident(w http.ResponseWriter, r *http.Request) {
 		DesiredReplicas int    `json:"desired_replicas"`
 		Status          string `json:"status"`
 		Error           string `json:"error"`
+		Attempts        int    `json:"attempts"`
+		MaxAttempts     int    `json:"max_attempts,omitempty"`
+		NextRetryAt     string `json:"next_retry_at,omitempty"`
 		CreatedAt       string `json:"created_at"`
 		UpdatedAt       string `json:"updated_at"`
 	}
@@ -154,148 +149,312 @@ func (a *API) getIncident(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	var actions []Action
-	arows, err := a.db.Query(`SELECT action_id, kind, desired_replicas, status, COALESCE(error,''), created_at, updated_at FROM actions WHERE incident_id=$1 ORDER BY id`, id)
+	arows, err := a.db.Query(`SELECT action_id, kind, desired_replicas, status, COALESCE(error,''), attempts, COALESCE(max_attempts,0), COALESCE(next_retry_at,''), created_at, updated_at FROM actions WHERE incident_id=$1 ORDER BY id`, id)
 	if err == nil {
 		defer arows.Close()
 		for arows.Next() {
 			var ac Action
-			if err := arows.Scan(&ac.ActionID, &ac.Kind, &ac.DesiredReplicas, &ac.Status, &ac.Error, &ac.CreatedAt, &ac.UpdatedAt); err == nil {
+			if err := arows.Scan(&ac.ActionID, &ac.Kind, &ac.DesiredReplicas, &ac.Status, &ac.Error, &ac.Attempts, &ac.MaxAttempts, &ac.NextRetryAt, &ac.CreatedAt, &ac.UpdatedAt); err == nil {
 				actions = append(actions, ac)
 			}
 		}
 	}
 	resp := map[string]any{
-		"incident_id": incidentID,
-		"alert_fp":    alertFP,
-		"status":      status,
-		"created_at":  createdAt,
-		"updated_at":  updatedAt,
-		"events":      events,
-		"actions":     actions,
-	}
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(resp)
+		"incident_id":     incidentID,
+		"alert_fp":        alertFP,
+		"status":          status,
+		"labels":          labels,
+		"created_at":      createdAt,
+		"updated_at":      updatedAt,
+		"events":          events,
+		"assignee":        assignee,
+		"acknowledged_by": ackBy,
+		"acknowledged_at": ackAt,
+		"actions":         actions,
+	}
+	writeJSON(w, http.StatusOK, resp)
 }
 
-func insertInbox(db *sql.DB, key, now string) error {
+func insertInbox(db storage.Querier, key, now string) error {
 	_, err := db.Exec(`INSERT INTO inbox (dedup_key, created_at) VALUES ($1,$2)`, key, now)
 	return err
 }
 
-func (a *API) appendIncidentEvent(incidentID, typ string, payload []byte, now string) {
-	_, _ = a.db.Exec(`INSERT INTO incident_events (incident_id, type, payload, created_at) VALUES ($1,$2,$3,$4)`,
+func appendIncidentEvent(db storage.Querier, incidentID, typ string, payload []byte, now string) error {
+	_, err := db.Exec(`INSERT INTO incident_events (incident_id, type, payload, created_at) VALUES ($1,$2,$3,$4)`,
 		incidentID, typ, string(payload), now)
+	return err
+}
+
+// openIncident creates the incident announced by incident.opened under the id
+// chosen by the rule engine; redelivery of the same event is a no-op.
+func openIncident(db storage.Querier, ev events.IncidentOpened, now string) error {
+	labels := ev.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	lb, _ := json.Marshal(labels)
+	_, err := db.Exec(`INSERT INTO incidents (incident_id, alert_fp, correlation_id, labels, status, created_at, updated_at) VALUES ($1,$2,NULLIF($3,''),$4,'open',$5,$5)
+		ON CONFLICT (incident_id) DO NOTHING`, ev.IncidentID, ev.AlertFP, ev.CorrelationID, string(lb), now)
+	return err
 }
 
-func (a *API) upsertIncident(alertFP, status, now string) (string, error) {
-	// Try to find latest open/mitigating incident for this alert_fp
+// errIncidentPending is returned for an event that names an incident whose
+// incident.opened has not been consumed yet. It is not permanent, so the
+// consumer retries the event and dead-letters it if the incident never shows up.
+var errIncidentPending = errors.New("incident not stored yet")
+
+// findIncident resolves the incident an action result belongs to: by the
+// propagated incident_id when set, otherwise the latest incident for the
+// alert fingerprint. Events arrive from several topics without a global
+// order, so an unknown incident_id is not resolved by fingerprint, which would
+// attach the event to the previous episode's incident; errIncidentPending is
+// returned instead. Returns sql.ErrNoRows when an event without incident_id
+// matches nothing.
+func findIncident(db storage.Querier, incidentID, alertFP string) (string, error) {
 	var id string
-	err := a.db.QueryRow(`SELECT incident_id FROM incidents WHERE alert_fp=$1 AND status IN ('open','mitigating') ORDER BY id DESC LIMIT 1`, alertFP).Scan(&id)
-	if err == sql.ErrNoRows {
-		id = fmt.Sprintf("inc-%d", time.Now().UnixNano())
-		_, err = a.db.Exec(`INSERT INTO incidents (incident_id, alert_fp, status, created_at, updated_at) VALUES ($1,$2,$3,$4,$4)`,
-			id, alertFP, status, now)
-		if err != nil {
-			return "", err
+	if incidentID != "" {
+		err := db.QueryRow(`SELECT incident_id FROM incidents WHERE incident_id=$1`, incidentID).Scan(&id)
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("incident %s: %w", incidentID, errIncidentPending)
 		}
-		return id, nil
+		return id, err
 	}
-	if err != nil {
-		return "", err
-	}
-	// Update status
-	_, err = a.db.Exec(`UPDATE incidents SET status=$1, updated_at=$2 WHERE incident_id=$3`, status, now, id)
+	err := db.QueryRow(`SELECT incident_id FROM incidents WHERE alert_fp=$1 ORDER BY id DESC LIMIT 1`, alertFP).Scan(&id)
 	return id, err
 }
 
-func (a *API) setIncidentStatusByFP(alertFP, status, now string) (string, error) {
-	var id string
-	err := a.db.QueryRow(`SELECT incident_id FROM incidents WHERE alert_fp=$1 ORDER BY id DESC LIMIT 1`, alertFP).Scan(&id)
+// activeIncidents returns unresolved incidents of an alert episode; incidents
+// without a recorded episode are matched by fingerprint.
+func activeIncidents(db storage.Querier, alertFP, correlationID string) ([]string, error) {
+	rows, err := db.Query(`SELECT incident_id FROM incidents
+		WHERE alert_fp=$1 AND status<>'resolved' AND (correlation_id IS NULL OR correlation_id=$2 OR $2='')
+		ORDER BY id`, alertFP, correlationID)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	_, err = a.db.Exec(`UPDATE incidents SET status=$1, updated_at=$2 WHERE incident_id=$3`, status, now, id)
-	return id, err
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
 }
 
-func (a *API) processMessage(msg kafka.Message) error {
-	var m map[string]any
-	if err := json.Unmarshal(msg.Value, &m); err != nil {
-		return err
+// applyTransition applies an event-driven status change. Events arrive from
+// several topics without a global order, so an illegal transition (e.g. a
+// late scale-up completion after the alert resolved) is logged and ignored.
+func applyTransition(db storage.Querier, incidentID, to, reason, now string) error {
+	_, err := transitionIncident(db, incidentID, to, reason, "", now)
+	if errors.Is(err, errIllegalTransition) {
+		log.Printf("incident %s: %v", incidentID, err)
+		return nil
 	}
-	now := time.Now().UTC().Format(time.RFC3339)
-	dedup, _ := m["dedup_key"].(string)
-	if dedup == "" {
-		dedup = fmt.Sprintf("%d", time.Now().UnixNano())
+	return err
+}
+
+func setIncidentStatus(db storage.Querier, incidentID, status, now string) error {
+	_, err := db.Exec(`UPDATE incidents SET status=$1, updated_at=$2 WHERE incident_id=$3`, status, now, incidentID)
+	return err
+}
+
+// processMessage applies one incident/action/alert event. The inbox key and all
+// resulting writes share a transaction, so a failed attempt can be retried.
+func (a *API) processMessage(ctx context.Context, msg kafka.Message) error {
+	env, err := events.Peek(msg.Value)
+	if err != nil {
+		return consumer.Permanent(err)
 	}
-	if err := insertInbox(a.db, dedup, now); err != nil {
-		// Best-effort unique detection
-		s := strings.ToLower(err.Error())
-		if strings.Contains(s, "unique") || strings.Contains(s, "duplicate") {
-			return nil
+	now := time.Now().UTC().Format(time.RFC3339)
+	var dedup string
+	var apply func(tx *sql.Tx) error
+	switch env.Type {
+	case events.TypeIncidentOpened:
+		ev, err := events.DecodeIncidentOpened(msg.Value)
+		if err != nil {
+			return consumer.Permanent(err)
 		}
-		return err
-	}
-
-	typ, _ := m["type"].(string)
-	switch typ {
-	case "incident.opened":
-		alertFP, _ := m["alert_fp"].(string)
-		if alertFP == "" {
-			// ignore if missing; nothing to correlate
-			return nil
+		dedup = ev.DedupKey
+		apply = func(tx *sql.Tx) error {
+			if err := openIncident(tx, ev, now); err != nil {
+				return err
+			}
+			return appendIncidentEvent(tx, ev.IncidentID, ev.Type, msg.Value, now)
+		}
+	case events.TypeActionRequested:
+		ev, err := events.DecodeActionRequested(msg.Value)
+		if err != nil {
+			return consumer.Permanent(err)
+		}
+		dedup = ev.DedupKey
+		apply = func(tx *sql.Tx) error {
+			id, err := findIncident(tx, ev.IncidentID, ev.AlertFP)
+			if err == sql.ErrNoRows {
+				id = ""
+			} else if err != nil {
+				return err
+			} else {
+				if err := appendIncidentEvent(tx, id, ev.Type, msg.Value, now); err != nil {
+					return err
+				}
+				if err := applyTransition(tx, id, statusMitigating, ev.Type, now); err != nil {
+					return err
+				}
+			}
+			_, err = tx.Exec(`INSERT INTO actions (action_id, incident_id, correlation_id, kind, desired_replicas, status, created_at, updated_at)
+				VALUES ($1,NULLIF($2,''),NULLIF($3,''),$4,$5,'requested',$6,$6)
+				ON CONFLICT (action_id) DO NOTHING`,
+				ev.ActionID, id, ev.CorrelationID, ev.Kind, ev.DesiredReplicas, now)
+			return err
 		}
-		id, err := a.upsertIncident(alertFP, "open", now)
+	case events.TypeActionCompleted, events.TypeActionFailed, events.TypeActionCancelled:
+		ev, err := events.DecodeActionResult(msg.Value)
 		if err != nil {
+			return consumer.Permanent(err)
+		}
+		dedup = ev.DedupKey
+		apply = func(tx *sql.Tx) error {
+			// A completed action means mitigation is in progress; only the alert
+			// resolving marks the incident resolved. A cancelled action leaves
+			// the incident status alone.
+			status := statusMitigating
+			switch ev.Type {
+			case events.TypeActionFailed:
+				status = statusFailed
+			case events.TypeActionCancelled:
+				status = ""
+			}
+			// Link by incident_id, or the latest incident for alert_fp when unset
+			id, err := findIncident(tx, ev.IncidentID, ev.AlertFP)
+			if err == sql.ErrNoRows {
+				// If no incident found, just ignore linking
+				log.Printf("%s: incident not found for id=%q fp=%s", ev.Type, ev.IncidentID, ev.AlertFP)
+				id = ""
+			} else if err != nil {
+				return err
+			} else {
+				if err := appendIncidentEvent(tx, id, ev.Type, msg.Value, now); err != nil {
+					return err
+				}
+				if status != "" {
+					if err := applyTransition(tx, id, status, ev.Type, now); err != nil {
+						return err
+					}
+				}
+			}
+			// Upsert action
+			actionStatus := "completed"
+			switch ev.Type {
+			case events.TypeActionFailed:
+				actionStatus = "failed"
+			case events.TypeActionCancelled:
+				actionStatus = "cancelled"
+			}
+			_, err = tx.Exec(`INSERT INTO actions (action_id, incident_id, correlation_id, kind, desired_replicas, status, error, attempts, created_at, updated_at)
+				VALUES ($1,NULLIF($2,''),NULLIF($3,''),$4,$5,$6,NULLIF($7,''),$8,$9,$9)
+				ON CONFLICT (action_id) DO UPDATE SET incident_id=COALESCE(EXCLUDED.incident_id, actions.incident_id), status=EXCLUDED.status, error=EXCLUDED.error,
+					attempts=GREATEST(actions.attempts, EXCLUDED.attempts), next_retry_at=NULL, updated_at=EXCLUDED.updated_at`,
+				ev.ActionID, id, ev.CorrelationID, ev.Kind, ev.DesiredReplicas, actionStatus, ev.Error, ev.Attempt, now)
 			return err
 		}
-		pjson, _ := json.Marshal(m)
-		a.appendIncidentEvent(id, typ, pjson, now)
-	case "action.completed":
-		actionID, _ := m["action_id"].(string)
-		alertFP, _ := m["alert_fp"].(string)
-		kind, _ := m["kind"].(string)
-		desired := 0
-		if v, ok := m["desired_replicas"].(float64); ok {
-			desired = int(v)
+	case events.TypeActionRetrying:
+		ev, err := events.DecodeActionResult(msg.Value)
+		if err != nil {
+			return consumer.Permanent(err)
+		}
+		dedup = ev.DedupKey
+		apply = func(tx *sql.Tx) error {
+			// A failed attempt that will be retried only shows progress; the
+			// incident status changes with the final result.
+			id, err := findIncident(tx, ev.IncidentID, ev.AlertFP)
+			if err == sql.ErrNoRows {
+				log.Printf("%s: incident not found for id=%q fp=%s", ev.Type, ev.IncidentID, ev.AlertFP)
+				id = ""
+			} else if err != nil {
+				return err
+			} else if err := appendIncidentEvent(tx, id, ev.Type, msg.Value, now); err != nil {
+				return err
+			}
+			// Topics are not ordered against each other: never overwrite a
+			// final result or a later attempt.
+			_, err = tx.Exec(`INSERT INTO actions (action_id, incident_id, correlation_id, kind, desired_replicas, status, error, attempts, max_attempts, next_retry_at, created_at, updated_at)
+				VALUES ($1,NULLIF($2,''),NULLIF($3,''),$4,$5,'retrying',$6,$7,$8,$9,$10,$10)
+				ON CONFLICT (action_id) DO UPDATE SET incident_id=COALESCE(EXCLUDED.incident_id, actions.incident_id), status='retrying', error=EXCLUDED.error,
+					attempts=EXCLUDED.attempts, max_attempts=EXCLUDED.max_attempts, next_retry_at=EXCLUDED.next_retry_at, updated_at=EXCLUDED.updated_at
+				WHERE actions.status IN ('requested','retrying') AND actions.attempts < EXCLUDED.attempts`,
+				ev.ActionID, id, ev.CorrelationID, ev.Kind, ev.DesiredReplicas, ev.Error, ev.Attempt, ev.MaxAttempts, ev.RetryAt, now)
+			return err
 		}
-		// Link to latest incident by alert_fp
-		id, err := a.setIncidentStatusByFP(alertFP, "resolved", now)
+	case events.TypeIncidentNote:
+		ev, err := events.DecodeIncidentNote(msg.Value)
 		if err != nil {
-			// If no incident found, just ignore linking
-			log.Printf("action.completed: incident not found for fp=%s: %v", alertFP, err)
-		} else {
-			pjson, _ := json.Marshal(m)
-			a.appendIncidentEvent(id, typ, pjson, now)
+			return consumer.Permanent(err)
 		}
-		// Upsert action
-		_, _ = a.db.Exec(`INSERT INTO actions (action_id, incident_id, kind, desired_replicas, status, created_at, updated_at)
-			VALUES ($1,$2,$3,$4,'completed',$5,$5)
-			ON CONFLICT (action_id) DO UPDATE SET status='completed', updated_at=$5`, actionID, id, kind, desired, now)
-	case "action.failed":
-		actionID, _ := m["action_id"].(string)
-		alertFP, _ := m["alert_fp"].(string)
-		errText, _ := m["error"].(string)
-		kind, _ := m["kind"].(string)
-		desired := 0
-		if v, ok := m["desired_replicas"].(float64); ok {
-			desired = int(v)
+		dedup = ev.DedupKey
+		apply = func(tx *sql.Tx) error {
+			id, err := findIncident(tx, ev.IncidentID, ev.AlertFP)
+			if err == sql.ErrNoRows {
+				log.Printf("%s: incident not found for id=%q fp=%s", ev.Type, ev.IncidentID, ev.AlertFP)
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`UPDATE incidents SET updated_at=$1 WHERE incident_id=$2`, now, id); err != nil {
+				return err
+			}
+			return appendIncidentEvent(tx, id, ev.Type, msg.Value, now)
 		}
-		id, err := a.setIncidentStatusByFP(alertFP, "failed", now)
+	case events.TypeAlertRaised:
+		ev, err := events.DecodeAlertRaised(msg.Value)
 		if err != nil {
-			log.Printf("action.failed: incident not found for fp=%s: %v", alertFP, err)
-		} else {
-			pjson, _ := json.Marshal(m)
-			a.appendIncidentEvent(id, typ, pjson, now)
+			return consumer.Permanent(err)
+		}
+		if ev.Status != events.StatusResolved {
+			return nil
+		}
+		dedup = ev.DedupKey
+		apply = func(tx *sql.Tx) error {
+			ids, err := activeIncidents(tx, ev.Fingerprint, ev.EpisodeID)
+			if err != nil {
+				return err
+			}
+			for _, id := range ids {
+				if err := appendIncidentEvent(tx, id, ev.Type, msg.Value, now); err != nil {
+					return err
+				}
+				if err := applyTransition(tx, id, statusResolved, "alert.resolved", now); err != nil {
+					return err
+				}
+			}
+			return nil
 		}
-		_, _ = a.db.Exec(`INSERT INTO actions (action_id, incident_id, kind, desired_replicas, status, error, created_at, updated_at)
-			VALUES ($1,$2,$3,$4,'failed',$5,$6,$6)
-			ON CONFLICT (action_id) DO UPDATE SET status='failed', error=$5, updated_at=$6`, actionID, id, kind, desired, errText, now)
 	default:
 		// ignore
 		return nil
 	}
-	return nil
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if err := insertInbox(tx, dedup, now); err != nil {
+		// Best-effort unique detection
+		s := strings.ToLower(err.Error())
+		if strings.Contains(s, "unique") || strings.Contains(s, "duplicate") {
+			return nil
+		}
+		return err
+	}
+	if err := apply(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func main() {
@@ -330,11 +489,31 @@ func main() {
 	if topicFailed == "" {
 		topicFailed = "action.failed"
 	}
+	topicRetrying := os.Getenv("KAFKA_TOPIC_ACTION_RETRYING")
+	if topicRetrying == "" {
+		topicRetrying = "action.retrying"
+	}
+	topicCancelled := os.Getenv("KAFKA_TOPIC_ACTION_CANCELLED")
+	if topicCancelled == "" {
+		topicCancelled = "action.cancelled"
+	}
+	topicNote := os.Getenv("KAFKA_TOPIC_INCIDENT_NOTE")
+	if topicNote == "" {
+		topicNote = "incident.note"
+	}
+	topicRequested := os.Getenv("KAFKA_TOPIC_ACTION_REQUESTED")
+	if topicRequested == "" {
+		topicRequested = "action.requested"
+	}
+	topicAlert := os.Getenv("KAFKA_TOPIC_ALERT_RAISED")
+	if topicAlert == "" {
+		topicAlert = "alert.raised"
+	}
 
 	reader := kafka.NewReader(kafka.ReaderConfig{
 		Brokers:     brokers,
 		GroupID:     "incident-api",
-		GroupTopics: []string{topicIncident, topicCompleted, topicFailed},
+		GroupTopics: []string{topicIncident, topicNote, topicRequested, topicCompleted, topicFailed, topicRetrying, topicCancelled, topicAlert},
 	})
 
 	api := &API{db: db, ready: true, reader: reader}
@@ -342,7 +521,11 @@ func main() {
 	http.HandleFunc("/health", api.handleHealth)
 	http.HandleFunc("/ready", api.handleReady)
 	http.HandleFunc("/incidents", api.listIncidents)
-	http.HandleFunc("/incidents/", api.getIncident)
+	http.HandleFunc("/incidents/", api.handleIncident)
+
+	// Dead-letter queue: failed messages go to <topic>.dlq and can be replayed
+	deadLetters := dlq.New(db, brokers, "incident-api")
+	deadLetters.Register(http.DefaultServeMux)
 
 	go func() {
 		log.Printf("incident-api listening on :8091")
@@ -351,17 +534,8 @@ func main() {
 		}
 	}()
 
-	log.Printf("incident-api consuming topics: %s, %s, %s", topicIncident, topicCompleted, topicFailed)
-	for {
-		ctx := context.Background()
-		msg, err := reader.ReadMessage(ctx)
-		if err != nil {
-			log.Printf("read error: %v", err)
-			time.Sleep(1 * time.Second)
-			continue
-		}
-		if err := api.processMessage(msg); err != nil {
-			log.Printf("process error: %v", err)
-		}
-	}
+	log.Printf("incident-api consuming topics: %s, %s, %s, %s, %s, %s, %s, %s", topicIncident, topicNote, topicRequested, topicCompleted, topicFailed, topicRetrying, topicCancelled, topicAlert)
+	c := &consumer.Consumer{Name: "incident-api", Reader: reader, OnFailure: deadLetters.Add, Handler: api.processMessage}
+	c.LoadEnv()
+	c.Run(context.Background())
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/ilya2309548/EventPulse/internal/storage"
+)
+
+// Incident statuses.
+const (
+	statusOpen       = "open"
+	statusMitigating = "mitigating"
+	statusResolved   = "resolved"
+	statusFailed     = "failed"
+)
+
+// transitions lists the statuses reachable from each status:
+//
+//	open       -> mitigating (action requested/completed), resolved (alert resolved), failed (action failed)
+//	mitigating -> resolved, failed
+//	failed     -> mitigating (compensation), resolved, open (reopen)
+//	resolved   -> open (reopen)
+var transitions = map[string][]string{
+	statusOpen:       {statusMitigating, statusResolved, statusFailed},
+	statusMitigating: {statusResolved, statusFailed},
+	statusFailed:     {statusMitigating, statusResolved, statusOpen},
+	statusResolved:   {statusOpen},
+}
+
+var errIllegalTransition = errors.New("illegal incident status transition")
+
+func canTransition(from, to string) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// transition is recorded in incident_events as a status.changed entry.
+type transition struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Reason string `json:"reason"`
+	Actor  string `json:"actor,omitempty"`
+}
+
+// transitionIncident moves an incident to status to, recording the change in
+// incident_events. Moving to the current status is a no-op; a transition not
+// allowed by the state machine returns errIllegalTransition.
+func transitionIncident(db storage.Querier, incidentID, to, reason, actor, now string) (bool, error) {
+	var from string
+	err := db.QueryRow(`SELECT status FROM incidents WHERE incident_id=$1 FOR UPDATE`, incidentID).Scan(&from)
+	if err == sql.ErrNoRows {
+		return false, fmt.Errorf("incident %s not found: %w", incidentID, err)
+	}
+	if err != nil {
+		return false, err
+	}
+	if from == to {
+		return false, nil
+	}
+	if !canTransition(from, to) {
+		return false, fmt.Errorf("%w: %s -> %s (%s)", errIllegalTransition, from, to, reason)
+	}
+	if err := setIncidentStatus(db, incidentID, to, now); err != nil {
+		return false, err
+	}
+	payload, _ := json.Marshal(transition{From: from, To: to, Reason: reason, Actor: actor})
+	if err := appendIncidentEvent(db, incidentID, "status.changed", payload, now); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{statusOpen, statusMitigating, true},
+		{statusOpen, statusResolved, true},
+		{statusOpen, statusFailed, true},
+		{statusMitigating, statusResolved, true},
+		{statusMitigating, statusFailed, true},
+		{statusMitigating, statusOpen, false},
+		{statusFailed, statusMitigating, true},
+		{statusFailed, statusResolved, true},
+		{statusFailed, statusOpen, true},
+		{statusResolved, statusOpen, true},
+		{statusResolved, statusMitigating, false},
+		{statusResolved, statusFailed, false},
+		{statusOpen, statusOpen, false},
+		{"unknown", statusOpen, false},
+		{statusOpen, "unknown", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from+"->"+tt.to, func(t *testing.T) {
+			if got := canTransition(tt.from, tt.to); got != tt.want {
+				t.Errorf("canTransition(%q, %q) = %v, want %v", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
@@ -9,12 +9,15 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	kafka "github.com/segmentio/kafka-go"
 
 	"github.com/ilya2309548/EventPulse/internal/common"
+	"github.com/ilya2309548/EventPulse/internal/events"
+	"github.com/ilya2309548/EventPulse/internal/outbox"
 	"github.com/ilya2309548/EventPulse/internal/storage"
 )
 
@@ -33,9 +36,9 @@ type Webhook struct {
 }
 
 type Server struct {
-	db          *sql.DB
-	ready       bool
-	alertWriter *kafka.Writer
+	db    *sql.DB
+	ready bool
+	relay *outbox.Relay
 }
 
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
@@ -53,6 +56,29 @@ func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write([]byte("not-ready"))
 }
 
+// handleOutbox reports the size of the unpublished outbox backlog.
+func (s *Server) handleOutbox(w http.ResponseWriter, r *http.Request) {
+	st, err := s.relay.Stats(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(st)
+}
+
+// newEpisode reports whether alert a starts a new firing episode compared to the
+// stored state: a firing after a resolve, or a different startsAt from Alertmanager.
+func newEpisode(prevStatus, prevStartsAt, prevEpisode string, a Alert) bool {
+	if prevEpisode == "" {
+		return true
+	}
+	if a.Status == "firing" && prevStatus == "resolved" {
+		return true
+	}
+	return a.StartsAt != "" && prevStartsAt != "" && a.StartsAt != prevStartsAt
+}
+
 func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	body, err := io.ReadAll(r.Body)
@@ -72,64 +98,64 @@ func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	// Collect messages to publish after DB commit
-	var msgs []kafka.Message
 	// Process each alert; upsert-like: increment occurrences by fingerprint
 	for _, a := range wh.Alerts {
 		labelsJSON, _ := json.Marshal(a.Labels)
 		annotationsJSON, _ := json.Marshal(a.Annotations)
-		// Try update existing alert by fingerprint; if not exists, insert
-		res, err := tx.Exec(`UPDATE alerts SET status=$1, labels=$2, annotations=$3, starts_at=$4, ends_at=$5, last_seen=$6, occurrences=occurrences+1 WHERE fingerprint=$7`,
-			a.Status, string(labelsJSON), string(annotationsJSON), a.StartsAt, a.EndsAt, now, a.Fingerprint)
-		if err != nil {
+		// Load previous state to decide whether this notification starts a new episode
+		var prevStatus, prevStartsAt, prevEpisode sql.NullString
+		err := tx.QueryRow(`SELECT status, starts_at, episode_id FROM alerts WHERE fingerprint=$1 ORDER BY id DESC LIMIT 1 FOR UPDATE`, a.Fingerprint).
+			Scan(&prevStatus, &prevStartsAt, &prevEpisode)
+		found := err == nil
+		if err != nil && err != sql.ErrNoRows {
 			_ = tx.Rollback()
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		rows, _ := res.RowsAffected()
-		if rows == 0 {
-			_, err = tx.Exec(`INSERT INTO alerts (fingerprint, status, labels, annotations, starts_at, ends_at, first_seen, last_seen, occurrences) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,1)`,
-				a.Fingerprint, a.Status, string(labelsJSON), string(annotationsJSON), a.StartsAt, a.EndsAt, now, now)
-			if err != nil {
-				_ = tx.Rollback()
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
+		episode := prevEpisode.String
+		if !found || newEpisode(prevStatus.String, prevStartsAt.String, episode, a) {
+			episode = fmt.Sprintf("ep-%d", time.Now().UnixNano())
+		}
+		if found {
+			_, err = tx.Exec(`UPDATE alerts SET status=$1, labels=$2, annotations=$3, starts_at=$4, ends_at=$5, last_seen=$6, episode_id=$7, occurrences=occurrences+1 WHERE fingerprint=$8`,
+				a.Status, string(labelsJSON), string(annotationsJSON), a.StartsAt, a.EndsAt, now, episode, a.Fingerprint)
+		} else {
+			_, err = tx.Exec(`INSERT INTO alerts (fingerprint, status, labels, annotations, starts_at, ends_at, first_seen, last_seen, episode_id, occurrences) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,1)`,
+				a.Fingerprint, a.Status, string(labelsJSON), string(annotationsJSON), a.StartsAt, a.EndsAt, now, now, episode)
+		}
+		if err != nil {
+			_ = tx.Rollback()
+			w.WriteHeader(http.StatusInternalServerError)
+			return
 		}
 		// Write outbox event alert.raised
-		// Deduplication key: fingerprint + event_type + status
-		dedupKey := fmt.Sprintf("%s:%s:%s", a.Fingerprint, "alert.raised", a.Status)
-		payload := map[string]any{
-			"type":        "alert.raised",
-			"fingerprint": a.Fingerprint,
-			"status":      a.Status,
-			"labels":      a.Labels,
-			"annotations": a.Annotations,
-			"dedup_key":   dedupKey,
-			"created_at":  now,
+		// Deduplication key: fingerprint + event_type + episode + status, so every
+		// firing -> resolved cycle is delivered once per status
+		ev := events.NewAlertRaised(a.Fingerprint, a.Status, episode)
+		ev.Labels = a.Labels
+		ev.Annotations = a.Annotations
+		ev.StartsAt = a.StartsAt
+		ev.EndsAt = a.EndsAt
+		ev.CreatedAt = now
+		if err := ev.Validate(); err != nil {
+			_ = tx.Rollback()
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-		pjson, _ := json.Marshal(payload)
-		_, err = tx.Exec(`INSERT INTO outbox_events (type, payload, created_at) VALUES ($1,$2,$3)`, "alert.raised", string(pjson), now)
+		pjson, _ := json.Marshal(ev)
+		_, err = tx.Exec(`INSERT INTO outbox_events (type, payload, created_at) VALUES ($1,$2,$3)`, ev.Type, string(pjson), now)
 		if err != nil {
 			_ = tx.Rollback()
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		// Prepare Kafka message (best-effort after commit)
-		msgs = append(msgs, kafka.Message{Value: pjson})
 	}
 	if err := tx.Commit(); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	// Publish to Kafka (best-effort)
-	if s.alertWriter != nil && len(msgs) > 0 {
-		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-		defer cancel()
-		if err := s.alertWriter.WriteMessages(ctx, msgs...); err != nil {
-			log.Printf("kafka publish alert.raised failed: %v", err)
-		}
-	}
+	// Outbox relay publishes committed events; wake it up instead of waiting for the next poll
+	s.relay.Notify()
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte("ok"))
 }
@@ -157,20 +183,49 @@ func main() {
 	if brokersEnv != "" {
 		brokers := strings.Split(brokersEnv, ",")
 		writer = &kafka.Writer{
-			Addr:     kafka.TCP(brokers...),
-			Topic:    topicAlert,
-			Balancer: &kafka.LeastBytes{},
+			Addr:         kafka.TCP(brokers...),
+			Topic:        topicAlert,
+			Balancer:     &kafka.LeastBytes{},
+			RequiredAcks: kafka.RequireAll,
 		}
 		log.Printf("kafka writer configured: brokers=%v topic=%s", brokers, topicAlert)
 	} else {
 		log.Printf("kafka writer disabled: KAFKA_BROKERS not set")
 	}
 
-	srv := &Server{db: db, ready: true, alertWriter: writer}
+	// Outbox relay: publishes outbox_events in order, retrying with backoff
+	writers := map[string]*kafka.Writer{}
+	if writer != nil {
+		writers[events.TypeAlertRaised] = writer
+	}
+	relay := outbox.NewRelay(db, writers)
+	if v := strings.TrimSpace(os.Getenv("OUTBOX_POLL_INTERVAL")); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			relay.SetInterval(d)
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("OUTBOX_MAX_BACKOFF")); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			relay.SetMaxBackoff(d)
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("OUTBOX_BATCH_SIZE")); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			relay.SetBatchSize(n)
+		}
+	}
+	if writer != nil {
+		go relay.Run(context.Background())
+	} else {
+		log.Printf("outbox relay disabled: events stay in outbox_events")
+	}
+
+	srv := &Server{db: db, ready: true, relay: relay}
 
 	http.HandleFunc("/health", srv.handleHealth)
 	http.HandleFunc("/ready", srv.handleReady)
 	http.HandleFunc("/alertmanager", srv.handleWebhook)
+	http.HandleFunc("/outbox", srv.handleOutbox)
 
 	// Ensure data dir exists
 	_ = os.MkdirAll("/data", 0o755)
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+
+	"github.com/ilya2309548/EventPulse/internal/consumer"
+	"github.com/ilya2309548/EventPulse/internal/events"
+)
+
+// planCompensation decides what to request for a failed action: the
+// compensation actions of the rule that requested it, unless the rule has no
+// policy or the compensation chain already used up its attempts.
+func planCompensation(rule *Rule, failed events.ActionResult) ([]events.ActionRequested, decision) {
+	d := decision{
+		Kind:          decisionCompensation,
+		AlertFP:       failed.AlertFP,
+		Rule:          failed.Rule,
+		CorrelationID: failed.CorrelationID,
+		Details: map[string]any{
+			"failed_action": failed.ActionID,
+			"kind":          failed.Kind,
+			"error":         failed.Error,
+		},
+	}
+	if failed.CompensationOf != "" {
+		d.Details["compensation_of"] = failed.CompensationOf
+	}
+	switch {
+	case failed.Rule == "":
+		d.Outcome, d.Reason = outcomeSkipped, "action was not requested by a rule"
+		return nil, d
+	case rule == nil:
+		d.Outcome, d.Reason = outcomeSkipped, "rule no longer exists"
+		return nil, d
+	case rule.Compensation == nil:
+		d.Outcome, d.Reason = outcomeSkipped, "rule has no compensation policy"
+		return nil, d
+	}
+	attempt := failed.CompensationNo + 1
+	max := rule.Compensation.maxAttempts()
+	d.Details["attempt"], d.Details["max_attempts"] = attempt, max
+	if attempt > max {
+		d.Outcome, d.Reason = outcomeExhausted, fmt.Sprintf("%d compensation attempt(s) used", max)
+		return nil, d
+	}
+	origin := failed.CompensationOf
+	if origin == "" {
+		origin = failed.ActionID
+	}
+	var reqs []events.ActionRequested
+	for _, act := range rule.Compensation.Actions {
+		req := act.request(failed.AlertFP, rule.Name)
+		if act.Target != nil {
+			// Validated not to reference alert labels
+			target, err := act.Target.resolve(nil)
+			if err != nil {
+				log.Printf("rule %s: skip compensation action: %v", rule.Name, err)
+				continue
+			}
+			req.Target = target
+		} else if req.Kind == failed.Kind {
+			req.Target = failed.Target
+			if req.TargetRunner == "" {
+				req.TargetRunner = failed.TargetRunner
+			}
+		}
+		req.IncidentID = failed.IncidentID
+		req.CorrelationID = failed.CorrelationID
+		req.CausationID = failed.ActionID
+		req.CompensationOf = origin
+		req.CompensationNo = attempt
+		if err := req.Validate(); err != nil {
+			log.Printf("rule %s: skip compensation action: %v", rule.Name, err)
+			continue
+		}
+		reqs = append(reqs, req)
+		d.Events = append(d.Events, req.ActionID)
+	}
+	if len(reqs) == 0 {
+		d.Outcome, d.Reason = outcomeSkipped, "no valid compensation action"
+		return nil, d
+	}
+	d.Outcome = outcomeEmitted
+	return reqs, d
+}
+
+// processActionFailed compensates one action.failed message. The inbox key,
+// the decision and the compensating actions are written in one transaction.
+func (re *RuleEngine) processActionFailed(ctx context.Context, msg kafka.Message) error {
+	env, err := events.Peek(msg.Value)
+	if err != nil {
+		return consumer.Permanent(err)
+	}
+	if env.Type != events.TypeActionFailed {
+		return nil
+	}
+	failed, err := events.DecodeActionResult(msg.Value)
+	if err != nil {
+		return consumer.Permanent(err)
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	var rule *Rule
+	if failed.Rule != "" {
+		r, err := getRuleByName(re.db, failed.Rule)
+		if err == nil {
+			rule = &r
+		} else if !errors.Is(err, errRuleNotFound) {
+			return err
+		}
+	}
+	reqs, d := planCompensation(rule, failed)
+
+	tx, err := re.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if err := insertInbox(tx, failed.DedupKey, now); err != nil {
+		if isUniqueViolation(err) {
+			return nil
+		}
+		return err
+	}
+	if err := logDecision(tx, d, now); err != nil {
+		return err
+	}
+	for _, req := range reqs {
+		pjson, _ := json.Marshal(req)
+		if err := writeOutbox(tx, req.Type, string(pjson), now); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("compensation for %s (rule %q): %s %s", failed.ActionID, failed.Rule, d.Outcome, d.Reason)
+	if len(reqs) > 0 {
+		re.relay.Notify()
+	}
+	return nil
+}
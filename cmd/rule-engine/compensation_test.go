@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ilya2309548/EventPulse/internal/events"
+)
+
+func TestPlanCompensation(t *testing.T) {
+	failedScale := func(no int) events.ActionResult {
+		req := events.NewActionRequested("fp", events.KindScaleDocker)
+		req.Rule = "scale-up"
+		req.DesiredReplicas = 3
+		req.IncidentID = "inc"
+		req.Target = &events.Target{Service: "api", MinReplicas: 1, MaxReplicas: 4}
+		if no > 0 {
+			req.CompensationOf = "origin"
+			req.CompensationNo = no
+		}
+		return events.NewActionFailed(req, errors.New("boom"))
+	}
+	scaleBack := RuleAction{Kind: events.KindScaleDocker, Params: map[string]any{"desired_replicas": 1.0}}
+	restart := RuleAction{Kind: events.KindRestartRunner, Params: map[string]any{"target_runner": "action-runner-b"}}
+	rule := func(max int, acts ...RuleAction) *Rule {
+		return &Rule{Name: "scale-up", Compensation: &Compensation{Actions: acts, MaxAttempts: max}}
+	}
+
+	tests := []struct {
+		name        string
+		rule        *Rule
+		failed      events.ActionResult
+		wantOutcome string
+		wantReason  string
+		wantReqs    int
+		check       func(t *testing.T, reqs []events.ActionRequested)
+	}{
+		{
+			name:        "not requested by a rule",
+			rule:        rule(1, scaleBack),
+			failed:      func() events.ActionResult { f := failedScale(0); f.Rule = ""; return f }(),
+			wantOutcome: outcomeSkipped,
+			wantReason:  "action was not requested by a rule",
+		},
+		{
+			name:        "rule missing",
+			failed:      failedScale(0),
+			wantOutcome: outcomeSkipped,
+			wantReason:  "rule no longer exists",
+		},
+		{
+			name:        "no policy",
+			rule:        &Rule{Name: "scale-up"},
+			failed:      failedScale(0),
+			wantOutcome: outcomeSkipped,
+			wantReason:  "rule has no compensation policy",
+		},
+		{
+			name:        "attempt above max",
+			rule:        rule(2, scaleBack),
+			failed:      failedScale(2),
+			wantOutcome: outcomeExhausted,
+			wantReason:  "2 compensation attempt(s) used",
+		},
+		{
+			name:        "default max is one attempt",
+			rule:        rule(0, scaleBack),
+			failed:      failedScale(1),
+			wantOutcome: outcomeExhausted,
+			wantReason:  "1 compensation attempt(s) used",
+		},
+		{
+			name:        "same kind inherits the failed target",
+			rule:        rule(1, scaleBack),
+			failed:      failedScale(0),
+			wantOutcome: outcomeEmitted,
+			wantReqs:    1,
+			check: func(t *testing.T, reqs []events.ActionRequested) {
+				r := reqs[0]
+				if r.Target == nil || r.Target.Service != "api" || r.DesiredReplicas != 1 {
+					t.Errorf("request = %+v, want desired 1 on the failed target", r)
+				}
+				if r.CompensationOf == "" || r.CompensationNo != 1 || r.IncidentID != "inc" || r.Rule != "scale-up" {
+					t.Errorf("request does not link the failed action: %+v", r)
+				}
+			},
+		},
+		{
+			name:        "chain keeps the original action",
+			rule:        rule(3, scaleBack),
+			failed:      failedScale(1),
+			wantOutcome: outcomeEmitted,
+			wantReqs:    1,
+			check: func(t *testing.T, reqs []events.ActionRequested) {
+				if reqs[0].CompensationOf != "origin" || reqs[0].CompensationNo != 2 {
+					t.Errorf("compensation_of = %q, no = %d; want origin, 2", reqs[0].CompensationOf, reqs[0].CompensationNo)
+				}
+			},
+		},
+		{
+			name:        "foreign kind does not inherit the target",
+			rule:        rule(1, restart),
+			failed:      failedScale(0),
+			wantOutcome: outcomeEmitted,
+			wantReqs:    1,
+			check: func(t *testing.T, reqs []events.ActionRequested) {
+				r := reqs[0]
+				if r.Kind != events.KindRestartRunner || r.Target != nil || r.TargetRunner != "action-runner-b" {
+					t.Errorf("request = %+v, want a restart of action-runner-b without target", r)
+				}
+			},
+		},
+		{
+			name:        "invalid actions are skipped",
+			rule:        rule(1, RuleAction{Kind: events.KindRestartRunner}),
+			failed:      failedScale(0),
+			wantOutcome: outcomeSkipped,
+			wantReason:  "no valid compensation action",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqs, d := planCompensation(tt.rule, tt.failed)
+			if d.Kind != decisionCompensation || d.Outcome != tt.wantOutcome {
+				t.Fatalf("decision = %s/%s (%s), want %s", d.Kind, d.Outcome, d.Reason, tt.wantOutcome)
+			}
+			if tt.wantReason != "" && d.Reason != tt.wantReason {
+				t.Errorf("reason = %q, want %q", d.Reason, tt.wantReason)
+			}
+			if len(reqs) != tt.wantReqs || len(d.Events) != tt.wantReqs {
+				t.Fatalf("requests = %d, events = %d, want %d", len(reqs), len(d.Events), tt.wantReqs)
+			}
+			if tt.check != nil {
+				tt.check(t, reqs)
+			}
+		})
+	}
+}
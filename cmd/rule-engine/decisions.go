@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ilya2309548/EventPulse/internal/events"
+	"github.com/ilya2309548/EventPulse/internal/storage"
+)
+
+// Decision kinds and outcomes recorded in decisions_log.
+const (
+	decisionCompensation = "compensation"
+	decisionThrottle     = "throttle"
+	decisionScaling      = "scaling"
+	// decisionAlert summarizes one evaluated alert, decisionRule explains one rule for it.
+	decisionAlert = "alert"
+	decisionRule  = "rule"
+
+	outcomeEmitted   = "emitted"
+	outcomeSkipped   = "skipped"
+	outcomeExhausted = "exhausted"
+	outcomeDeferred  = "deferred"
+	outcomeReleased  = "released"
+	outcomeMatched   = "matched"
+	outcomeNoMatch   = "no_match"
+	outcomeDuplicate = "duplicate"
+	outcomeShadow    = "shadow"
+)
+
+// decision is one step of the rule engine's reasoning. The whole value is
+// stored as JSON in decisions_log.decision; the fields used for lookups are
+// also stored in their own columns.
+type decision struct {
+	Kind          string         `json:"kind"`
+	AlertFP       string         `json:"alert_fp,omitempty"`
+	Rule          string         `json:"rule,omitempty"`
+	CorrelationID string         `json:"correlation_id,omitempty"`
+	Outcome       string         `json:"outcome"`
+	Reason        string         `json:"reason,omitempty"`
+	Events        []string       `json:"events,omitempty"` // ids of the emitted events
+	Details       map[string]any `json:"details,omitempty"`
+}
+
+// skipAction records an action of a matched rule that was not emitted.
+func (d *decision) skipAction(req events.ActionRequested, reason string) {
+	if d.Details == nil {
+		d.Details = map[string]any{}
+	}
+	skipped, _ := d.Details["skipped_actions"].([]map[string]any)
+	d.Details["skipped_actions"] = append(skipped, map[string]any{"action_id": req.ActionID, "kind": req.Kind, "reason": reason})
+}
+
+func migrateDecisions(db storage.Querier) error {
+	stmts := []string{
+		`ALTER TABLE decisions_log ADD COLUMN IF NOT EXISTS kind TEXT`,
+		`ALTER TABLE decisions_log ADD COLUMN IF NOT EXISTS alert_fp TEXT`,
+		`ALTER TABLE decisions_log ADD COLUMN IF NOT EXISTS rule TEXT`,
+		`ALTER TABLE decisions_log ADD COLUMN IF NOT EXISTS correlation_id TEXT`,
+		`ALTER TABLE decisions_log ADD COLUMN IF NOT EXISTS outcome TEXT`,
+		`CREATE INDEX IF NOT EXISTS idx_decisions_alert ON decisions_log(alert_fp, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_decisions_rule ON decisions_log(rule, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_decisions_created ON decisions_log(created_at)`,
+	}
+	for _, s := range stmts {
+		if _, err := db.Exec(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func logDecision(db storage.Querier, d decision, now string) error {
+	b, _ := json.Marshal(d)
+	_, err := db.Exec(`INSERT INTO decisions_log (decision, kind, alert_fp, rule, correlation_id, outcome, created_at)
+		VALUES ($1,$2,NULLIF($3,''),NULLIF($4,''),NULLIF($5,''),$6,$7)`,
+		string(b), d.Kind, d.AlertFP, d.Rule, d.CorrelationID, d.Outcome, now)
+	return err
+}
+
+// pruneDecisions periodically removes decisions older than ttl.
+func (re *RuleEngine) pruneDecisions(ttl, interval time.Duration) {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		res, err := re.db.ExecContext(ctx, `DELETE FROM decisions_log WHERE created_at < $1`, time.Now().Add(-ttl).UTC().Format(time.RFC3339))
+		cancel()
+		if err != nil {
+			log.Printf("decisions prune failed: %v", err)
+		} else if n, _ := res.RowsAffected(); n > 0 {
+			log.Printf("decisions prune: removed %d decisions older than %s", n, ttl)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// decisionRecord is a decisions_log row as served by the API.
+type decisionRecord struct {
+	ID        int64  `json:"id"`
+	CreatedAt string `json:"created_at"`
+	decision
+}
+
+// queryDecisions returns decisions matching where, newest first.
+func (re *RuleEngine) queryDecisions(ctx context.Context, where string, args []any, limit int) ([]decisionRecord, error) {
+	args = append(args, limit)
+	rows, err := re.db.QueryContext(ctx, `SELECT id, decision, created_at FROM decisions_log
+		WHERE `+where+` ORDER BY id DESC LIMIT $`+strconv.Itoa(len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []decisionRecord{}
+	for rows.Next() {
+		var rec decisionRecord
+		var raw string
+		if err := rows.Scan(&rec.ID, &raw, &rec.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(raw), &rec.decision); err != nil {
+			rec.Reason = "undecodable decision: " + err.Error()
+		}
+		items = append(items, rec)
+	}
+	return items, rows.Err()
+}
+
+func parseLimit(v string, def int) (int, bool) {
+	if v == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(v)
+	return n, err == nil && n >= 1 && n <= 1000
+}
+
+// handleDecisions serves GET /decisions?alert_fp=&rule=&kind=&outcome=&correlation_id=&since=&until=&limit=,
+// newest first. since and until are RFC3339 timestamps.
+func (re *RuleEngine) handleDecisions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	limit, ok := parseLimit(q.Get("limit"), 100)
+	if !ok {
+		http.Error(w, "limit must be 1..1000", http.StatusBadRequest)
+		return
+	}
+	where := "TRUE"
+	var args []any
+	for _, f := range []string{"alert_fp", "rule", "kind", "outcome", "correlation_id"} {
+		if v := strings.TrimSpace(q.Get(f)); v != "" {
+			args = append(args, v)
+			where += " AND " + f + " = $" + strconv.Itoa(len(args))
+		}
+	}
+	for _, f := range []struct{ param, op string }{{"since", ">="}, {"until", "<"}} {
+		v := strings.TrimSpace(q.Get(f.param))
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, f.param+" must be an RFC3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		args = append(args, t.UTC().Format(time.RFC3339))
+		where += " AND created_at " + f.op + " $" + strconv.Itoa(len(args))
+	}
+	items, err := re.queryDecisions(r.Context(), where, args, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// handleAlertDecisions serves GET /decisions/{alert_fp}[?correlation_id=&limit=]:
+// the latest decisions about one alert in the order they were made, to
+// explain why it did or did not lead to actions.
+func (re *RuleEngine) handleAlertDecisions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	fp := strings.Trim(strings.TrimPrefix(r.URL.Path, "/decisions/"), "/")
+	if fp == "" {
+		http.Error(w, "missing alert fingerprint", http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	limit, ok := parseLimit(q.Get("limit"), 500)
+	if !ok {
+		http.Error(w, "limit must be 1..1000", http.StatusBadRequest)
+		return
+	}
+	where, args := "alert_fp = $1", []any{fp}
+	if v := strings.TrimSpace(q.Get("correlation_id")); v != "" {
+		args = append(args, v)
+		where += " AND correlation_id = $2"
+	}
+	items, err := re.queryDecisions(r.Context(), where, args, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(items) == 0 {
+		http.Error(w, "no decisions for alert "+fp, http.StatusNotFound)
+		return
+	}
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"alert_fp": fp, "decisions": items})
+}
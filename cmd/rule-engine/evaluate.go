@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ilya2309548/EventPulse/internal/events"
+)
+
+// evaluation is the result of running the rules against one alert: the events
+// to publish and the decisions explaining them. plan does the part that
+// writes nothing; apply runs the throttles in the caller's transaction, which
+// processAlert commits and POST /rules/test rolls back.
+type evaluation struct {
+	alert         events.AlertRaised
+	dedup         string
+	correlationID string
+	causationID   string
+	rules         int
+
+	matched   []Rule // enabled, live rules whose matcher accepts the alert
+	shadowed  []Rule // enabled shadow rules whose matcher accepts the alert
+	evaluated []*decision
+	byRule    map[string]*decision
+
+	incidentID  string
+	newIncident bool
+	planned     []plannedAction
+	msgs        []outMsg
+	summary     decision
+	steps       []decision // scaling and throttling
+}
+
+type plannedAction struct {
+	rule Rule
+	req  events.ActionRequested
+}
+
+// decisions returns what to log: the summary first, then each rule, then the
+// scaling and throttling steps.
+func (ev *evaluation) decisions() []decision {
+	all := []decision{ev.summary}
+	for _, d := range ev.evaluated {
+		all = append(all, *d)
+	}
+	return append(all, ev.steps...)
+}
+
+// alertKeys returns the inbox dedup key and the correlation id of an alert.
+func alertKeys(alert events.AlertRaised) (dedup, correlationID string) {
+	// Inbox dedup: fingerprint + event_type + episode + status at Rule Engine scope.
+	// The episode changes on every new firing, so a re-firing alert is processed again.
+	episode := alert.EpisodeID
+	if episode == "" {
+		episode = alert.StartsAt
+	}
+	dedup = fmt.Sprintf("%s:%s:%s:%s", alert.Fingerprint, events.TypeAlertRaised, episode, alert.Status)
+
+	// Correlation: all events of one alert episode share the episode id; they
+	// are caused by this alert.raised event.
+	correlationID = alert.EpisodeID
+	if correlationID == "" {
+		correlationID = dedup
+	}
+	return dedup, correlationID
+}
+
+// plan matches the rules against the alert and builds the incident and the
+// actions of matched rules.
+func (re *RuleEngine) plan(ctx context.Context, rules []Rule, alert events.AlertRaised) (*evaluation, error) {
+	fingerprint, status := alert.Fingerprint, alert.Status
+	dedup, correlationID := alertKeys(alert)
+	ev := &evaluation{alert: alert, dedup: dedup, correlationID: correlationID, causationID: alert.DedupKey,
+		rules: len(rules), byRule: map[string]*decision{}}
+
+	// Every rule is explained in decisions_log: matched, not matched and why,
+	// or disabled; matched rules also list their emitted and skipped actions.
+	for _, rule := range rules {
+		d := &decision{Kind: decisionRule, AlertFP: fingerprint, Rule: rule.Name, CorrelationID: correlationID, Outcome: outcomeMatched}
+		if !rule.Enabled {
+			d.Outcome, d.Reason = outcomeSkipped, "rule disabled"
+		} else if why := rule.Match.mismatch(status, alert.Labels); why != "" {
+			d.Outcome, d.Reason = outcomeNoMatch, why
+		} else if rule.Shadow {
+			d.Outcome, d.Reason = outcomeShadow, "shadow rule, nothing emitted"
+			ev.shadowed = append(ev.shadowed, rule)
+		} else {
+			ev.matched = append(ev.matched, rule)
+		}
+		ev.evaluated = append(ev.evaluated, d)
+		ev.byRule[rule.Name] = d
+	}
+
+	// Decision: every enabled rule whose matcher accepts the alert contributes its
+	// actions; at most one incident is opened per alert even if several rules ask for it.
+	// Actions carry the incident of this episode: the one opened now, or the one
+	// opened when the episode started firing.
+	for _, rule := range ev.matched {
+		if rule.OpenIncident && status == events.StatusFiring && !ev.newIncident {
+			inc := ev.incident(rule)
+			ev.msgs = append(ev.msgs, outMsg{typ: inc.Type, body: inc})
+			ev.incidentID = inc.IncidentID
+			ev.newIncident = true
+			ev.byRule[rule.Name].Events = append(ev.byRule[rule.Name].Events, inc.IncidentID)
+		}
+	}
+	if !ev.newIncident && len(ev.matched)+len(ev.shadowed) > 0 {
+		var err error
+		ev.incidentID, err = lookupIncident(re.db, fingerprint, correlationID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, rule := range ev.matched {
+		for _, req := range re.ruleActions(ctx, ev, rule, false) {
+			ev.planned = append(ev.planned, plannedAction{rule: rule, req: req})
+		}
+	}
+	// Shadow rules record the events they would have emitted
+	for _, rule := range ev.shadowed {
+		var would []any
+		if rule.OpenIncident && status == events.StatusFiring && !ev.newIncident {
+			would = append(would, ev.incident(rule))
+		}
+		for _, req := range re.ruleActions(ctx, ev, rule, true) {
+			would = append(would, req)
+		}
+		d := ev.byRule[rule.Name]
+		if d.Details == nil {
+			d.Details = map[string]any{}
+		}
+		d.Details["would_emit"] = would
+	}
+	return ev, nil
+}
+
+func (ev *evaluation) incident(rule Rule) events.IncidentOpened {
+	inc := events.NewIncidentOpened(ev.alert.Fingerprint)
+	inc.Rule = rule.Name
+	inc.Labels = ev.alert.Labels
+	inc.CorrelationID = ev.correlationID
+	inc.CausationID = ev.causationID
+	return inc
+}
+
+// ruleActions builds the action.requested events of a matched rule. Actions
+// that cannot be built are recorded on the rule's decision and left out. For a
+// shadow rule the runners are not asked for replica counts: scaling actions
+// are returned without desired_replicas and their step is recorded as shadow.
+func (re *RuleEngine) ruleActions(ctx context.Context, ev *evaluation, rule Rule, shadow bool) []events.ActionRequested {
+	var out []events.ActionRequested
+	for _, act := range rule.Actions {
+		req := act.request(ev.alert.Fingerprint, rule.Name)
+		if act.Target != nil {
+			target, err := act.Target.resolve(ev.alert.Labels)
+			if err != nil {
+				log.Printf("rule %s: skip action: %v", rule.Name, err)
+				ev.byRule[rule.Name].skipAction(req, err.Error())
+				continue
+			}
+			req.Target = target
+		}
+		req.IncidentID = ev.incidentID
+		req.CorrelationID = ev.correlationID
+		req.CausationID = ev.causationID
+		if act.Scaling != nil && shadow {
+			ev.steps = append(ev.steps, decision{
+				Kind: decisionScaling, AlertFP: ev.alert.Fingerprint, Rule: rule.Name, CorrelationID: ev.correlationID,
+				Outcome: outcomeShadow, Reason: "shadow rule, replicas are computed once it is live",
+				Details: map[string]any{"target": targetKey(req), "scaling": *act.Scaling},
+			})
+			out = append(out, req)
+			continue
+		}
+		if act.Scaling != nil {
+			d, ok := re.applyScaling(ctx, *act.Scaling, &req, ev.alert)
+			d.CorrelationID = ev.correlationID
+			ev.steps = append(ev.steps, d)
+			if !ok {
+				log.Printf("rule %s: skip action: %s", rule.Name, d.Reason)
+				ev.byRule[rule.Name].skipAction(req, d.Reason)
+				continue
+			}
+		}
+		if err := req.Validate(); err != nil {
+			log.Printf("rule %s: skip action: %v", rule.Name, err)
+			ev.byRule[rule.Name].skipAction(req, err.Error())
+			continue
+		}
+		out = append(out, req)
+	}
+	return out
+}
+
+// apply links the new incident, throttles the planned actions and completes
+// ev.msgs and the summary decision.
+func (re *RuleEngine) apply(tx *sql.Tx, ev *evaluation, now string) error {
+	fingerprint, status, correlationID := ev.alert.Fingerprint, ev.alert.Status, ev.correlationID
+	if ev.newIncident {
+		if err := linkIncident(tx, fingerprint, correlationID, ev.incidentID, now); err != nil {
+			return err
+		}
+	}
+	// Throttling: actions on a target that changed recently, or for a
+	// flapping alert, are deferred
+	at := time.Now()
+	if err := recordTransition(tx, fingerprint, status, at); err != nil {
+		return err
+	}
+	noted := false
+	summary := decision{Kind: decisionAlert, AlertFP: fingerprint, CorrelationID: correlationID, Outcome: outcomeEmitted,
+		Details: map[string]any{"status": status, "dedup_key": ev.dedup, "rules_evaluated": ev.rules}}
+	for _, p := range ev.planned {
+		res, err := throttle(tx, p.rule, p.req, status, at)
+		if err != nil {
+			return err
+		}
+		if res.flapping && !noted {
+			note := events.NewIncidentNote(ev.incidentID, fingerprint, "rule-engine",
+				fmt.Sprintf("alert is flapping (%d status changes within %s): actions on %s suppressed until %s",
+					res.flaps, p.rule.Throttle.FlapWindow, res.target, res.until.UTC().Format(time.RFC3339)))
+			note.CorrelationID = correlationID
+			note.CausationID = ev.causationID
+			ev.msgs = append(ev.msgs, outMsg{typ: note.Type, body: note})
+			noted = true
+			summary.Events = append(summary.Events, note.DedupKey)
+		}
+		if !res.deferred {
+			ev.msgs = append(ev.msgs, outMsg{typ: p.req.Type, body: p.req})
+			ev.byRule[p.rule.Name].Events = append(ev.byRule[p.rule.Name].Events, p.req.ActionID)
+			continue
+		}
+		log.Printf("rule %s: action %s on %s deferred until %s (%s)", p.rule.Name, p.req.ActionID, res.target, res.until.UTC().Format(time.RFC3339), res.reason)
+		ev.byRule[p.rule.Name].skipAction(p.req, "deferred: "+res.reason)
+		ev.steps = append(ev.steps, decision{
+			Kind: decisionThrottle, AlertFP: fingerprint, Rule: p.rule.Name, CorrelationID: correlationID,
+			Outcome: outcomeDeferred, Reason: res.reason, Events: []string{p.req.ActionID},
+			Details: map[string]any{"target": res.target, "until": res.until.UTC().Format(time.RFC3339), "desired_replicas": p.req.DesiredReplicas},
+		})
+	}
+	names, shadow := []string{}, []string{}
+	for _, rule := range ev.matched {
+		names = append(names, rule.Name)
+		summary.Events = append(summary.Events, ev.byRule[rule.Name].Events...)
+	}
+	for _, rule := range ev.shadowed {
+		shadow = append(shadow, rule.Name)
+	}
+	summary.Details["matched"], summary.Details["shadow"] = names, shadow
+	switch {
+	case len(ev.matched) == 0 && len(ev.shadowed) > 0:
+		summary.Outcome, summary.Reason = outcomeSkipped, "only shadow rules matched"
+	case len(ev.matched) == 0:
+		summary.Outcome, summary.Reason = outcomeNoMatch, "no enabled rule matched"
+	case len(summary.Events) == 0:
+		summary.Outcome, summary.Reason = outcomeSkipped, "matched rules emitted nothing"
+	}
+	ev.summary = summary
+	return nil
+}
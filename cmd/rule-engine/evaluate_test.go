@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ilya2309548/EventPulse/internal/events"
+)
+
+func TestRuleActionsScaling(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		_, _ = w.Write([]byte(`{"replicas":2}`))
+	}))
+	defer srv.Close()
+	re := &RuleEngine{replicas: newReplicaSource([]string{srv.URL}), bounds: replicaBounds{"*": {1, 4}}}
+	rule := Rule{Name: "scale-up", Actions: []RuleAction{{Kind: events.KindScaleDocker, Scaling: &ScalingPolicy{Mode: scaleStep, Step: 1}}}}
+	alert := events.NewAlertRaised("fp", events.StatusFiring, "ep")
+
+	tests := []struct {
+		name        string
+		shadow      bool
+		wantCalls   int32
+		wantDesired int
+		wantOutcome string
+	}{
+		{"live rule asks the runners", false, 1, 3, outcomeEmitted},
+		{"shadow rule does not", true, 0, 0, outcomeShadow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls.Store(0)
+			ev := &evaluation{alert: alert, correlationID: "ep", byRule: map[string]*decision{rule.Name: {}}}
+			reqs := re.ruleActions(context.Background(), ev, rule, tt.shadow)
+			if n := calls.Load(); n != tt.wantCalls {
+				t.Errorf("replica requests = %d, want %d", n, tt.wantCalls)
+			}
+			if len(reqs) != 1 || reqs[0].DesiredReplicas != tt.wantDesired {
+				t.Fatalf("requests = %+v, want one with desired_replicas %d", reqs, tt.wantDesired)
+			}
+			if len(ev.steps) != 1 || ev.steps[0].Kind != decisionScaling || ev.steps[0].Outcome != tt.wantOutcome {
+				t.Fatalf("steps = %+v, want one scaling step %s", ev.steps, tt.wantOutcome)
+			}
+			if _, skipped := ev.byRule[rule.Name].Details["skipped_actions"]; skipped {
+				t.Errorf("action skipped: %v", ev.byRule[rule.Name].Details)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ilya2309548/EventPulse/internal/events"
+)
+
+// kindParam mirrors a parameter advertised by the action runner on GET /kinds.
+type kindParam struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Required bool   `json:"required"`
+}
+
+type kindInfo struct {
+	Kind   string      `json:"kind"`
+	Params []kindParam `json:"params"`
+}
+
+// builtinKinds is used when no action runner can be reached.
+var builtinKinds = []kindInfo{
+	{Kind: events.KindScaleDocker, Params: []kindParam{{Name: "desired_replicas", Type: "int", Required: true}}},
+	{Kind: events.KindRestartRunner, Params: []kindParam{{Name: "target_runner", Type: "string", Required: true}}},
+}
+
+// kindCatalog caches the action kinds supported by the action runners so
+// rules with unknown kinds are rejected when they are saved.
+type kindCatalog struct {
+	urls   []string
+	client *http.Client
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	kinds   map[string]kindInfo
+	fetched time.Time
+}
+
+func newKindCatalog(urls []string) *kindCatalog {
+	return &kindCatalog{urls: urls, client: &http.Client{Timeout: 2 * time.Second}, ttl: time.Minute}
+}
+
+func (c *kindCatalog) get() map[string]kindInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.kinds != nil && time.Since(c.fetched) < c.ttl {
+		return c.kinds
+	}
+	list, err := c.fetch()
+	if err != nil {
+		log.Printf("action kinds: %v; using built-in kinds", err)
+		list = builtinKinds
+	}
+	kinds := make(map[string]kindInfo, len(list))
+	for _, k := range list {
+		kinds[strings.ToLower(k.Kind)] = k
+	}
+	// A fallback is cached too, so an unreachable runner is not polled on every request
+	c.kinds, c.fetched = kinds, time.Now()
+	return kinds
+}
+
+// fetch asks each runner in turn; the first answer wins.
+func (c *kindCatalog) fetch() ([]kindInfo, error) {
+	var lastErr error = fmt.Errorf("no action runner configured")
+	for _, u := range c.urls {
+		resp, err := c.client.Get(strings.TrimRight(u, "/") + "/kinds")
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		var body struct {
+			Kinds []kindInfo `json:"kinds"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			lastErr = fmt.Errorf("%s/kinds: %s", u, resp.Status)
+			continue
+		}
+		if err != nil {
+			lastErr = fmt.Errorf("%s/kinds: %w", u, err)
+			continue
+		}
+		return body.Kinds, nil
+	}
+	return nil, lastErr
+}
+
+// check rejects actions whose kind no runner supports or that miss a
+// parameter the kind requires.
+func (c *kindCatalog) check(actions []RuleAction) error {
+	if c == nil {
+		return nil
+	}
+	kinds := c.get()
+	for i, a := range actions {
+		k, ok := kinds[strings.ToLower(a.Kind)]
+		if !ok {
+			return fmt.Errorf("actions[%d]: unknown action kind %q", i, a.Kind)
+		}
+		for _, p := range k.Params {
+			if p.Name == "desired_replicas" && a.Scaling != nil {
+				continue
+			}
+			if _, ok := a.Params[p.Name]; p.Required && !ok {
+				return fmt.Errorf("actions[%d]: %s requires param %s", i, a.Kind, p.Name)
+			}
+		}
+	}
+	return nil
+}
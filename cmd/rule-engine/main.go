@@ -4,8 +4,6 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
-	"errors"
-	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -18,14 +16,22 @@ import (
 	kafka "github.com/segmentio/kafka-go"
 
 	"github.com/ilya2309548/EventPulse/internal/common"
+	"github.com/ilya2309548/EventPulse/internal/consumer"
+	"github.com/ilya2309548/EventPulse/internal/dlq"
+	"github.com/ilya2309548/EventPulse/internal/events"
+	"github.com/ilya2309548/EventPulse/internal/outbox"
+	"github.com/ilya2309548/EventPulse/internal/storage"
 )
 
 type RuleEngine struct {
-	db             *sql.DB
-	ready          bool
-	alertReader    *kafka.Reader
-	incidentWriter *kafka.Writer
-	actionWriter   *kafka.Writer
+	db          *sql.DB
+	ready       bool
+	alertReader *kafka.Reader
+	relay       *outbox.Relay
+	kinds       *kindCatalog
+	replicas    *replicaSource
+	bounds      replicaBounds
+	monitor     *runnerMonitor
 }
 
 func migrate(db *sql.DB) error {
@@ -35,24 +41,74 @@ func migrate(db *sql.DB) error {
 			dedup_key TEXT NOT NULL UNIQUE,
 			created_at TEXT NOT NULL
 		)`,
+		`CREATE INDEX IF NOT EXISTS idx_inbox_created ON inbox(created_at)`,
 		`CREATE TABLE IF NOT EXISTS outbox_events (
 			id SERIAL PRIMARY KEY,
 			type TEXT NOT NULL,
 			payload TEXT NOT NULL,
 			created_at TEXT NOT NULL
 		)`,
+		`CREATE TABLE IF NOT EXISTS rules (
+			id SERIAL PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE,
+			enabled BOOLEAN NOT NULL DEFAULT TRUE,
+			priority INTEGER NOT NULL DEFAULT 0,
+			match TEXT NOT NULL,
+			open_incident BOOLEAN NOT NULL DEFAULT FALSE,
+			actions TEXT NOT NULL,
+			created_at TEXT NOT NULL,
+			updated_at TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS incident_links (
+			alert_fp TEXT NOT NULL,
+			correlation_id TEXT NOT NULL,
+			incident_id TEXT NOT NULL,
+			created_at TEXT NOT NULL,
+			PRIMARY KEY (alert_fp, correlation_id)
+		)`,
 		`CREATE TABLE IF NOT EXISTS decisions_log (
 			id SERIAL PRIMARY KEY,
 			decision TEXT NOT NULL,
 			created_at TEXT NOT NULL
 		)`,
+		// One row per applied rule seed, see seedOnce
+		`CREATE TABLE IF NOT EXISTS rule_seeds (
+			name TEXT PRIMARY KEY,
+			applied_at TEXT NOT NULL
+		)`,
+		`ALTER TABLE rules ADD COLUMN IF NOT EXISTS compensation TEXT`,
+		`ALTER TABLE rules ADD COLUMN IF NOT EXISTS shadow BOOLEAN NOT NULL DEFAULT FALSE`,
+		// Last payload per alert, for POST /rules/test {"alert_fp": ...}
+		`CREATE TABLE IF NOT EXISTS last_alerts (
+			alert_fp TEXT PRIMARY KEY,
+			payload TEXT NOT NULL,
+			received_at TEXT NOT NULL
+		)`,
 	}
 	for _, s := range stmts {
 		if _, err := db.Exec(s); err != nil {
 			return err
 		}
 	}
-	return nil
+	if err := migrateDecisions(db); err != nil {
+		return err
+	}
+	if err := migrateThrottle(db); err != nil {
+		return err
+	}
+	if err := migrateMonitor(db); err != nil {
+		return err
+	}
+	if err := storage.MigrateOutbox(db); err != nil {
+		return err
+	}
+	if err := dlq.Migrate(db); err != nil {
+		return err
+	}
+	if err := seedDefaultRules(db); err != nil {
+		return err
+	}
+	return migrateStepScaling(db)
 }
 
 func (re *RuleEngine) handleHealth(w http.ResponseWriter, r *http.Request) {
@@ -70,198 +126,124 @@ func (re *RuleEngine) handleReady(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write([]byte("not-ready"))
 }
 
-func insertInbox(db *sql.DB, key string, now string) error {
+func insertInbox(db storage.Querier, key string, now string) error {
 	_, err := db.Exec(`INSERT INTO inbox (dedup_key, created_at) VALUES ($1,$2)`, key, now)
 	return err
 }
 
-func writeOutbox(db *sql.DB, typ string, payload string, now string) error {
+func writeOutbox(db storage.Querier, typ string, payload string, now string) error {
 	_, err := db.Exec(`INSERT INTO outbox_events (type, payload, created_at) VALUES ($1,$2,$3)`, typ, payload, now)
 	return err
 }
 
-func (re *RuleEngine) processAlert(msg kafka.Message) error {
-	var payload map[string]any
-	if err := json.Unmarshal(msg.Value, &payload); err != nil {
-		return err
+// outMsg is an event produced by a decision; the outbox relay publishes it by type.
+type outMsg struct {
+	typ  string
+	body any
+}
+
+// processAlert evaluates rules for one alert.raised message. The inbox key and
+// resulting outbox events are written in one transaction, so a failed attempt
+// can be retried without losing or duplicating decisions.
+func (re *RuleEngine) processAlert(ctx context.Context, msg kafka.Message) error {
+	env, err := events.Peek(msg.Value)
+	if err != nil {
+		return consumer.Permanent(err)
 	}
-	// Expect fields: type, fingerprint, status, labels, annotations, dedup_key
-	typ, _ := payload["type"].(string)
-	if typ != "alert.raised" {
+	if env.Type != events.TypeAlertRaised {
 		return nil // ignore
 	}
-	fingerprint, _ := payload["fingerprint"].(string)
-	status, _ := payload["status"].(string)
+	alert, err := events.DecodeAlertRaised(msg.Value)
+	if err != nil {
+		return consumer.Permanent(err)
+	}
 	now := time.Now().UTC().Format(time.RFC3339)
+	dedup, correlationID := alertKeys(alert)
 
-	// Inbox dedup: fingerprint + event_type + status at Rule Engine scope
-	dedup := fmt.Sprintf("%s:%s:%s", fingerprint, "alert.raised", status)
-	if err := insertInbox(re.db, dedup, now); err != nil {
-		// unique violation -> skip
+	tx, err := re.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if err := insertInbox(tx, dedup, now); err != nil {
+		// unique violation -> already processed
 		if strings.Contains(err.Error(), "unique") || strings.Contains(strings.ToLower(err.Error()), "duplicate") {
+			_ = tx.Rollback()
+			if err := logDecision(re.db, decision{
+				Kind: decisionAlert, AlertFP: alert.Fingerprint, CorrelationID: correlationID,
+				Outcome: outcomeDuplicate, Reason: "already processed", Details: map[string]any{"dedup_key": dedup},
+			}, now); err != nil {
+				log.Printf("alert %s: log duplicate: %v", alert.Fingerprint, err)
+			}
 			return nil
 		}
 		return err
 	}
 
-	// Decision: for firing -> open incident + request scale to 2; for resolved -> request scale to 1
-	var outMsgs []struct {
-		topic string
-		typ   string
-		body  map[string]any
-	}
-
-	switch status {
-	case "firing":
-		actID := fmt.Sprintf("act-%d", time.Now().UnixNano())
-		incID := fmt.Sprintf("inc-%d", time.Now().UnixNano())
-		outMsgs = append(outMsgs,
-			struct {
-				topic, typ string
-				body       map[string]any
-			}{
-				topic: re.incidentWriter.Topic,
-				typ:   "incident.opened",
-				body: map[string]any{
-					"type":        "incident.opened",
-					"alert_fp":    fingerprint,
-					"incident_id": incID,
-					"created_at":  now,
-					"dedup_key":   incID,
-				},
-			},
-			struct {
-				topic, typ string
-				body       map[string]any
-			}{
-				topic: re.actionWriter.Topic,
-				typ:   "action.requested",
-				body: map[string]any{
-					"type":             "action.requested",
-					"alert_fp":         fingerprint,
-					"kind":             "scale_docker",
-					"desired_replicas": 2,
-					"action_id":        actID,
-					"created_at":       now,
-					"dedup_key":        actID,
-				},
-			},
-		)
-	case "resolved":
-		actID := fmt.Sprintf("act-%d", time.Now().UnixNano())
-		outMsgs = append(outMsgs,
-			struct {
-				topic, typ string
-				body       map[string]any
-			}{
-				topic: re.actionWriter.Topic,
-				typ:   "action.requested",
-				body: map[string]any{
-					"type":             "action.requested",
-					"alert_fp":         fingerprint,
-					"kind":             "scale_docker",
-					"desired_replicas": 1,
-					"action_id":        actID,
-					"created_at":       now,
-					"dedup_key":        actID,
-				},
-			},
-		)
-	default:
-		// ignore other statuses
-		return nil
-	}
-
-	// Write outbox and publish
-	for _, m := range outMsgs {
-		pjson, _ := json.Marshal(m.body)
-		if err := writeOutbox(re.db, m.typ, string(pjson), now); err != nil {
+	// Only a new alert is planned: planning asks the runners for replica counts
+	rules, err := loadRules(re.db, false)
+	if err != nil {
+		return err
+	}
+	ev, err := re.plan(ctx, rules, alert)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO last_alerts (alert_fp, payload, received_at) VALUES ($1,$2,$3)
+		ON CONFLICT (alert_fp) DO UPDATE SET payload=EXCLUDED.payload, received_at=EXCLUDED.received_at`,
+		alert.Fingerprint, string(msg.Value), now); err != nil {
+		return err
+	}
+	if err := re.apply(tx, ev, now); err != nil {
+		return err
+	}
+	for _, d := range ev.decisions() {
+		if err := logDecision(tx, d, now); err != nil {
 			return err
 		}
 	}
-	// Publish to Kafka
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	for _, m := range outMsgs {
+	for _, m := range ev.msgs {
 		pjson, _ := json.Marshal(m.body)
-		var writer *kafka.Writer
-		switch m.topic {
-		case re.incidentWriter.Topic:
-			writer = re.incidentWriter
-		case re.actionWriter.Topic:
-			writer = re.actionWriter
-		default:
-			return errors.New("unknown topic")
-		}
-		if err := writer.WriteMessages(ctx, kafka.Message{Value: pjson}); err != nil {
-			log.Printf("kafka publish failed: %v", err)
+		if err := writeOutbox(tx, m.typ, string(pjson), now); err != nil {
+			return err
 		}
 	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	re.relay.Notify()
 	return nil
 }
 
-// monitorRunners periodically checks health endpoints of configured runner services.
-// On sustained failure, it emits incident.opened(outage(service)) and action.requested(restart_runner).
-func (re *RuleEngine) monitorRunners(services []string, interval time.Duration, failThreshold int, cooldown time.Duration) {
-	client := &http.Client{Timeout: 2 * time.Second}
-	failCounts := make(map[string]int)
-	lastAction := make(map[string]time.Time)
+// linkIncident remembers the incident opened for an alert episode so later
+// actions of the same episode (e.g. scale-down on resolve) reference it.
+func linkIncident(db storage.Querier, alertFP, correlationID, incidentID, now string) error {
+	_, err := db.Exec(`INSERT INTO incident_links (alert_fp, correlation_id, incident_id, created_at) VALUES ($1,$2,$3,$4)
+		ON CONFLICT (alert_fp, correlation_id) DO NOTHING`, alertFP, correlationID, incidentID, now)
+	return err
+}
+
+// lookupIncident returns the incident opened for an alert episode, or "".
+func lookupIncident(db storage.Querier, alertFP, correlationID string) (string, error) {
+	var id string
+	err := db.QueryRow(`SELECT incident_id FROM incident_links WHERE alert_fp=$1 AND correlation_id=$2`, alertFP, correlationID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return id, err
+}
+
+// pruneInbox periodically removes inbox dedup keys older than ttl so the
+// table does not grow without bound.
+func (re *RuleEngine) pruneInbox(ttl, interval time.Duration) {
 	for {
-		for _, svc := range services {
-			svc = strings.TrimSpace(svc)
-			if svc == "" {
-				continue
-			}
-			url := fmt.Sprintf("http://%s:8092/health", svc)
-			ok := false
-			if resp, err := client.Get(url); err == nil && resp.StatusCode == 200 {
-				ok = true
-			}
-			if ok {
-				failCounts[svc] = 0
-				continue
-			}
-			failCounts[svc]++
-			if failCounts[svc] >= failThreshold {
-				// cooldown check
-				if t, ok := lastAction[svc]; ok && time.Since(t) < cooldown {
-					continue
-				}
-				now := time.Now().UTC().Format(time.RFC3339)
-				incID := fmt.Sprintf("inc-%d", time.Now().UnixNano())
-				actID := fmt.Sprintf("act-%d", time.Now().UnixNano())
-				// incident.opened
-				inc := map[string]any{
-					"type":        "incident.opened",
-					"alert_fp":    fmt.Sprintf("outage(%s)", svc),
-					"incident_id": incID,
-					"created_at":  now,
-					"dedup_key":   incID,
-				}
-				// action.requested restart_runner
-				act := map[string]any{
-					"type":          "action.requested",
-					"kind":          "restart_runner",
-					"alert_fp":      fmt.Sprintf("outage(%s)", svc),
-					"target_runner": svc,
-					"action_id":     actID,
-					"created_at":    now,
-					"dedup_key":     actID,
-				}
-				// outbox
-				p1, _ := json.Marshal(inc)
-				p2, _ := json.Marshal(act)
-				_ = writeOutbox(re.db, "incident.opened", string(p1), now)
-				_ = writeOutbox(re.db, "action.requested", string(p2), now)
-				// publish
-				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-				_ = re.incidentWriter.WriteMessages(ctx, kafka.Message{Value: p1})
-				_ = re.actionWriter.WriteMessages(ctx, kafka.Message{Value: p2})
-				cancel()
-				lastAction[svc] = time.Now()
-				// keep counter at threshold to avoid overflow
-				failCounts[svc] = failThreshold
-			}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		n, err := storage.PruneInbox(ctx, re.db, ttl)
+		cancel()
+		if err != nil {
+			log.Printf("inbox prune failed: %v", err)
+		} else if n > 0 {
+			log.Printf("inbox prune: removed %d keys older than %s", n, ttl)
 		}
 		time.Sleep(interval)
 	}
@@ -301,19 +283,61 @@ func main() {
 	if topicAction == "" {
 		topicAction = "action.requested"
 	}
+	topicFailed := os.Getenv("KAFKA_TOPIC_ACTION_FAILED")
+	if topicFailed == "" {
+		topicFailed = "action.failed"
+	}
+	topicNote := os.Getenv("KAFKA_TOPIC_INCIDENT_NOTE")
+	if topicNote == "" {
+		topicNote = "incident.note"
+	}
 
 	reader := kafka.NewReader(kafka.ReaderConfig{
 		Brokers: brokers,
 		Topic:   topicIn,
 		GroupID: "rule-engine",
 	})
-	incidentWriter := &kafka.Writer{Addr: kafka.TCP(brokers...), Topic: topicIncident, Balancer: &kafka.LeastBytes{}}
-	actionWriter := &kafka.Writer{Addr: kafka.TCP(brokers...), Topic: topicAction, Balancer: &kafka.LeastBytes{}}
+	incidentWriter := &kafka.Writer{Addr: kafka.TCP(brokers...), Topic: topicIncident, Balancer: &kafka.LeastBytes{}, RequiredAcks: kafka.RequireAll}
+	actionWriter := &kafka.Writer{Addr: kafka.TCP(brokers...), Topic: topicAction, Balancer: &kafka.LeastBytes{}, RequiredAcks: kafka.RequireAll}
+	noteWriter := &kafka.Writer{Addr: kafka.TCP(brokers...), Topic: topicNote, Balancer: &kafka.LeastBytes{}, RequiredAcks: kafka.RequireAll}
+	relay := outbox.NewRelay(db, map[string]*kafka.Writer{
+		events.TypeIncidentOpened:  incidentWriter,
+		events.TypeActionRequested: actionWriter,
+		events.TypeIncidentNote:    noteWriter,
+	})
+	go relay.Run(context.Background())
 
-	re := &RuleEngine{db: db, ready: true, alertReader: reader, incidentWriter: incidentWriter, actionWriter: actionWriter}
+	// Action kinds are checked against what the runners advertise on /kinds
+	runnerURLs := []string{"http://action-runner-a:8092", "http://action-runner-b:8092"}
+	if v := strings.TrimSpace(os.Getenv("ACTION_RUNNER_URLS")); v != "" {
+		runnerURLs = strings.Split(v, ",")
+	}
+
+	// Scaling policies are clamped to per-service bounds, "*" for the rest
+	boundsEnv := strings.TrimSpace(os.Getenv("SERVICE_REPLICA_BOUNDS"))
+	if boundsEnv == "" {
+		boundsEnv = "*=1:10"
+	}
+	bounds, err := parseReplicaBounds(boundsEnv)
+	if err != nil {
+		log.Fatalf("SERVICE_REPLICA_BOUNDS: %v", err)
+	}
+
+	re := &RuleEngine{db: db, ready: true, alertReader: reader, relay: relay, kinds: newKindCatalog(runnerURLs),
+		replicas: newReplicaSource(runnerURLs), bounds: bounds}
 
 	http.HandleFunc("/health", re.handleHealth)
 	http.HandleFunc("/ready", re.handleReady)
+	http.HandleFunc("/rules", re.handleRules)
+	http.HandleFunc("/rules/", re.handleRule)
+	http.HandleFunc("/rules/test", re.handleRuleTest)
+	http.HandleFunc("/decisions", re.handleDecisions)
+	http.HandleFunc("/decisions/", re.handleAlertDecisions)
+	http.HandleFunc("/monitor", re.handleMonitor)
+
+	// Dead-letter queue: failed messages go to <topic>.dlq and can be replayed
+	deadLetters := dlq.New(db, brokers, "rule-engine")
+	deadLetters.Register(http.DefaultServeMux)
 
 	go func() {
 		log.Printf("rule-engine listening on :8090")
@@ -322,6 +346,37 @@ func main() {
 		}
 	}()
 
+	// Inbox TTL: dedup keys older than this are pruned (0 disables pruning)
+	inboxTTL := 24 * time.Hour
+	if v := strings.TrimSpace(os.Getenv("INBOX_TTL")); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			inboxTTL = d
+		}
+	}
+	if inboxTTL > 0 {
+		pruneEvery := 10 * time.Minute
+		if inboxTTL < pruneEvery {
+			pruneEvery = inboxTTL
+		}
+		log.Printf("inbox ttl %s (prune every %s)", inboxTTL, pruneEvery)
+		go re.pruneInbox(inboxTTL, pruneEvery)
+	}
+
+	// Decisions are kept for postmortems longer than inbox keys (0 disables pruning)
+	decisionsTTL := 7 * 24 * time.Hour
+	if v := strings.TrimSpace(os.Getenv("DECISIONS_TTL")); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			decisionsTTL = d
+		}
+	}
+	if decisionsTTL > 0 {
+		go re.pruneDecisions(decisionsTTL, time.Hour)
+	}
+
+	// Actions deferred by rule throttles are emitted when due
+	go re.releaseDeferred(5 * time.Second)
+	go re.pruneTransitions(10 * time.Minute)
+
 	// Runner outage monitor (optional, enabled by env)
 	if svcs := strings.TrimSpace(os.Getenv("RUNNER_SERVICES")); svcs != "" {
 		services := []string{}
@@ -348,21 +403,38 @@ func main() {
 				cooldown = d
 			}
 		}
-		log.Printf("runner monitor enabled for %v (interval=%s threshold=%d cooldown=%s)", services, interval, failThreshold, cooldown)
-		go re.monitorRunners(services, interval, failThreshold, cooldown)
-	}
-
-	log.Printf("rule-engine consuming from %s", topicIn)
-	for {
-		ctx := context.Background()
-		msg, err := reader.ReadMessage(ctx)
-		if err != nil {
-			log.Printf("read error: %v", err)
-			time.Sleep(1 * time.Second)
-			continue
+		// Only the leader runs the monitor; the lease must outlive a few checks
+		leaseTTL := 15 * time.Second
+		if v := strings.TrimSpace(os.Getenv("MONITOR_LEASE_TTL")); v != "" {
+			if d, err := time.ParseDuration(v); err == nil {
+				leaseTTL = d
+			}
+		}
+		if leaseTTL < 3*interval {
+			leaseTTL = 3 * interval
 		}
-		if err := re.processAlert(msg); err != nil {
-			log.Printf("process error: %v", err)
+		holder := strings.TrimSpace(os.Getenv("RULE_ENGINE_ID"))
+		if holder == "" {
+			holder, _ = os.Hostname()
 		}
+		re.monitor = &runnerMonitor{services: services, interval: interval, failThreshold: failThreshold, cooldown: cooldown, holder: holder, leaseTTL: leaseTTL}
+		log.Printf("runner monitor enabled for %v (interval=%s threshold=%d cooldown=%s holder=%s lease=%s)", services, interval, failThreshold, cooldown, holder, leaseTTL)
+		go re.monitorRunners()
 	}
+
+	// Compensation: failed actions are answered with the rule's compensation
+	// policy
+	failedReader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: brokers,
+		Topic:   topicFailed,
+		GroupID: "rule-engine-compensation",
+	})
+	compensation := &consumer.Consumer{Name: "rule-engine-compensation", Reader: failedReader, OnFailure: deadLetters.Add, Handler: re.processActionFailed}
+	compensation.LoadEnv()
+	go compensation.Run(context.Background())
+
+	log.Printf("rule-engine consuming from %s", topicIn)
+	c := &consumer.Consumer{Name: "rule-engine", Reader: reader, OnFailure: deadLetters.Add, Handler: re.processAlert}
+	c.LoadEnv()
+	c.Run(context.Background())
 }
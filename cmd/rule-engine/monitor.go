@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ilya2309548/EventPulse/internal/events"
+)
+
+// monitorLease is the leader_leases row of the runner monitor.
+const monitorLease = "runner-monitor"
+
+// runnerMonitor configures the runner outage monitor. Every rule engine runs
+// it, but only the holder of the monitor lease checks the runners; fail
+// counts and cooldowns live in runner_monitor, so a new leader picks up where
+// the previous one stopped.
+type runnerMonitor struct {
+	services      []string
+	interval      time.Duration // RUNNER_CHECK_INTERVAL
+	failThreshold int           // RUNNER_FAIL_THRESHOLD
+	cooldown      time.Duration // RUNNER_COOLDOWN
+	holder        string        // RULE_ENGINE_ID, the hostname by default
+	leaseTTL      time.Duration // MONITOR_LEASE_TTL
+}
+
+// errNotLeader is returned when the monitor lease was lost mid-check.
+var errNotLeader = errors.New("not the runner monitor leader")
+
+func migrateMonitor(db *sql.DB) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS leader_leases (
+			name TEXT PRIMARY KEY,
+			holder TEXT NOT NULL,
+			acquired_at TIMESTAMPTZ NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS runner_monitor (
+			service TEXT PRIMARY KEY,
+			fail_count INTEGER NOT NULL DEFAULT 0,
+			last_check_at TIMESTAMPTZ,
+			last_action_at TIMESTAMPTZ,
+			checked_by TEXT
+		)`,
+	}
+	for _, s := range stmts {
+		if _, err := db.Exec(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lead takes the monitor lease if it is free or expired, or renews it if it
+// is ours, and reports whether this instance is the leader.
+func (re *RuleEngine) lead(ctx context.Context) (bool, error) {
+	m := re.monitor
+	var holder string
+	err := re.db.QueryRowContext(ctx, `INSERT INTO leader_leases (name, holder, acquired_at, expires_at)
+		VALUES ($1, $2, now(), now() + $3 * interval '1 millisecond')
+		ON CONFLICT (name) DO UPDATE SET holder=EXCLUDED.holder, expires_at=EXCLUDED.expires_at,
+			acquired_at=CASE WHEN leader_leases.holder = EXCLUDED.holder THEN leader_leases.acquired_at ELSE EXCLUDED.acquired_at END
+		WHERE leader_leases.expires_at < now() OR leader_leases.holder = EXCLUDED.holder
+		RETURNING holder`, monitorLease, m.holder, m.leaseTTL.Milliseconds()).Scan(&holder)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// monitorRunners periodically checks health endpoints of configured runner services.
+// On sustained failure, it emits incident.opened(outage(service)) and action.requested(restart_runner).
+func (re *RuleEngine) monitorRunners() {
+	m := re.monitor
+	client := &http.Client{Timeout: 2 * time.Second}
+	leader := false
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), m.interval)
+		ok, err := re.lead(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("runner monitor: lease: %v", err)
+			ok = false
+		}
+		if ok != leader {
+			if ok {
+				log.Printf("runner monitor: %s is the leader", m.holder)
+			} else {
+				log.Printf("runner monitor: %s is no longer the leader", m.holder)
+			}
+			leader = ok
+		}
+		if leader {
+			for _, svc := range m.services {
+				url := fmt.Sprintf("http://%s:8092/health", svc)
+				healthy := false
+				if resp, err := client.Get(url); err == nil {
+					resp.Body.Close()
+					healthy = resp.StatusCode == http.StatusOK
+				}
+				if err := re.checkRunner(svc, healthy); err != nil {
+					log.Printf("runner monitor: %s: %v", svc, err)
+					if errors.Is(err, errNotLeader) {
+						leader = false
+						break
+					}
+				}
+			}
+		}
+		time.Sleep(m.interval)
+	}
+}
+
+// checkRunner records a health check of svc and, once it failed
+// failThreshold times in a row and is out of cooldown, requests a restart.
+// The state and the events are written in one transaction that also renews
+// the lease, so an instance that lost the lease cannot act.
+func (re *RuleEngine) checkRunner(svc string, healthy bool) error {
+	m := re.monitor
+	tx, err := re.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	res, err := tx.Exec(`UPDATE leader_leases SET expires_at = now() + $1 * interval '1 millisecond'
+		WHERE name=$2 AND holder=$3 AND expires_at >= now()`, m.leaseTTL.Milliseconds(), monitorLease, m.holder)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errNotLeader
+	}
+	if healthy {
+		if _, err := tx.Exec(`INSERT INTO runner_monitor (service, fail_count, last_check_at, checked_by) VALUES ($1, 0, now(), $2)
+			ON CONFLICT (service) DO UPDATE SET fail_count=0, last_check_at=now(), checked_by=EXCLUDED.checked_by`, svc, m.holder); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+	var fails int
+	var cooling bool
+	err = tx.QueryRow(`INSERT INTO runner_monitor (service, fail_count, last_check_at, checked_by) VALUES ($1, 1, now(), $2)
+		ON CONFLICT (service) DO UPDATE SET fail_count=runner_monitor.fail_count+1, last_check_at=now(), checked_by=EXCLUDED.checked_by
+		RETURNING fail_count, COALESCE(last_action_at > now() - $3 * interval '1 millisecond', FALSE)`,
+		svc, m.holder, m.cooldown.Milliseconds()).Scan(&fails, &cooling)
+	if err != nil {
+		return err
+	}
+	if fails < m.failThreshold || cooling {
+		return tx.Commit()
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	// incident.opened
+	inc := events.NewIncidentOpened(fmt.Sprintf("outage(%s)", svc))
+	// action.requested restart_runner
+	inc.CorrelationID = inc.IncidentID
+	inc.Labels = map[string]string{"alertname": "RunnerOutage", "service": svc}
+	act := events.NewActionRequested(inc.AlertFP, events.KindRestartRunner)
+	act.TargetRunner = svc
+	act.IncidentID = inc.IncidentID
+	act.CorrelationID = inc.IncidentID
+	act.CausationID = inc.IncidentID
+	// outbox; the relay publishes both events
+	for _, msg := range []outMsg{{typ: inc.Type, body: inc}, {typ: act.Type, body: act}} {
+		pjson, _ := json.Marshal(msg.body)
+		if err := writeOutbox(tx, msg.typ, string(pjson), now); err != nil {
+			return err
+		}
+	}
+	// keep counter at threshold to avoid overflow
+	if _, err := tx.Exec(`UPDATE runner_monitor SET last_action_at=now(), fail_count=$1 WHERE service=$2`, m.failThreshold, svc); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	re.relay.Notify()
+	log.Printf("runner monitor: %s failed %d checks, restart requested (action %s)", svc, fails, act.ActionID)
+	return nil
+}
+
+type runnerState struct {
+	Service      string `json:"service"`
+	FailCount    int    `json:"fail_count"`
+	LastCheckAt  string `json:"last_check_at,omitempty"`
+	LastActionAt string `json:"last_action_at,omitempty"`
+	CheckedBy    string `json:"checked_by,omitempty"`
+}
+
+// handleMonitor serves GET /monitor: the current monitor leader and the
+// persisted state of each runner.
+func (re *RuleEngine) handleMonitor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	out := map[string]any{"leader": nil}
+	var holder string
+	var acquired, expires time.Time
+	err := re.db.QueryRowContext(r.Context(), `SELECT holder, acquired_at, expires_at FROM leader_leases WHERE name=$1 AND expires_at >= now()`,
+		monitorLease).Scan(&holder, &acquired, &expires)
+	switch {
+	case err == nil:
+		out["leader"] = map[string]any{"holder": holder, "acquired_at": acquired.UTC().Format(time.RFC3339), "expires_at": expires.UTC().Format(time.RFC3339)}
+	case err != sql.ErrNoRows:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rows, err := re.db.QueryContext(r.Context(), `SELECT service, fail_count, last_check_at, last_action_at, COALESCE(checked_by,'')
+		FROM runner_monitor ORDER BY service`)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	runners := []runnerState{}
+	for rows.Next() {
+		var st runnerState
+		var checked, acted sql.NullTime
+		if err := rows.Scan(&st.Service, &st.FailCount, &checked, &acted, &st.CheckedBy); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if checked.Valid {
+			st.LastCheckAt = checked.Time.UTC().Format(time.RFC3339)
+		}
+		if acted.Valid {
+			st.LastActionAt = acted.Time.UTC().Format(time.RFC3339)
+		}
+		runners = append(runners, st)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out["runners"] = runners
+	writeJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,658 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ilya2309548/EventPulse/internal/events"
+	"github.com/ilya2309548/EventPulse/internal/storage"
+)
+
+// Matcher selects alerts by status and labels. Empty fields match anything.
+type Matcher struct {
+	Status    string            `json:"status,omitempty"`
+	Alertname string            `json:"alertname,omitempty"`
+	Severity  string            `json:"severity,omitempty"`
+	Service   string            `json:"service,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// RuleAction is an action emitted as action.requested when a rule matches.
+type RuleAction struct {
+	Kind   string         `json:"kind"`
+	Params map[string]any `json:"params,omitempty"`
+	Target *TargetSpec    `json:"target,omitempty"`
+	// Scaling computes desired_replicas from the target's current replicas
+	// when the action is emitted, instead of a fixed params.desired_replicas;
+	// the default rules step by +1/-1 within SERVICE_REPLICA_BOUNDS. See
+	// ScalingPolicy.
+	Scaling *ScalingPolicy `json:"scaling,omitempty"`
+}
+
+// TargetSpec selects the containers a scale action applies to. The service
+// is either fixed (Service) or taken from an alert label (ServiceFrom); label
+// selector values of the form "$name" are replaced by the alert's label name.
+type TargetSpec struct {
+	Service     string            `json:"service,omitempty"`
+	ServiceFrom string            `json:"service_from,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	MinReplicas int               `json:"min_replicas,omitempty"`
+	MaxReplicas int               `json:"max_replicas,omitempty"`
+}
+
+// resolve builds the event target for an alert with the given labels.
+func (t TargetSpec) resolve(alertLabels map[string]string) (*events.Target, error) {
+	out := &events.Target{Service: t.Service, MinReplicas: t.MinReplicas, MaxReplicas: t.MaxReplicas}
+	if t.ServiceFrom != "" {
+		out.Service = alertLabels[t.ServiceFrom]
+		if out.Service == "" {
+			return nil, fmt.Errorf("target: alert has no label %q", t.ServiceFrom)
+		}
+	}
+	for k, v := range t.Labels {
+		if strings.HasPrefix(v, "$") {
+			name := strings.TrimPrefix(v, "$")
+			v = alertLabels[name]
+			if v == "" {
+				return nil, fmt.Errorf("target: alert has no label %q", name)
+			}
+		}
+		if out.Labels == nil {
+			out.Labels = map[string]string{}
+		}
+		out.Labels[k] = v
+	}
+	return out, out.Validate(events.TypeActionRequested)
+}
+
+// usesAlertLabels reports whether resolving t needs the alert's labels.
+func (t TargetSpec) usesAlertLabels() bool {
+	if t.ServiceFrom != "" {
+		return true
+	}
+	for _, v := range t.Labels {
+		if strings.HasPrefix(v, "$") {
+			return true
+		}
+	}
+	return false
+}
+
+func (t TargetSpec) validate() error {
+	if t.Service != "" && t.ServiceFrom != "" {
+		return errors.New("target: service and service_from are mutually exclusive")
+	}
+	// Check the shape with placeholder values for alert label references
+	placeholders := map[string]string{}
+	if t.ServiceFrom != "" {
+		placeholders[t.ServiceFrom] = "x"
+	}
+	for _, v := range t.Labels {
+		if strings.HasPrefix(v, "$") {
+			placeholders[strings.TrimPrefix(v, "$")] = "x"
+		}
+	}
+	_, err := t.resolve(placeholders)
+	return err
+}
+
+// Compensation is what a rule requests when one of its actions fails, e.g.
+// converging back to a single replica. A failed compensating action is
+// compensated again until MaxAttempts compensations were requested for the
+// original action. Actions without a target act on the failed action's target.
+type Compensation struct {
+	Actions     []RuleAction `json:"actions"`
+	MaxAttempts int          `json:"max_attempts,omitempty"` // default 1
+}
+
+func (c Compensation) maxAttempts() int {
+	if c.MaxAttempts < 1 {
+		return 1
+	}
+	return c.MaxAttempts
+}
+
+// Rule maps matching alerts to an optional incident and a list of actions.
+type Rule struct {
+	ID           int64   `json:"id"`
+	Name         string  `json:"name"`
+	Enabled      bool    `json:"enabled"`
+	Priority     int     `json:"priority"`
+	Match        Matcher `json:"match"`
+	OpenIncident bool    `json:"open_incident"`
+	// Shadow rules are evaluated and logged to decisions_log with the events
+	// they would emit, but emit nothing.
+	Shadow       bool          `json:"shadow"`
+	Actions      []RuleAction  `json:"actions"`
+	Compensation *Compensation `json:"compensation,omitempty"`
+	Throttle     *Throttle     `json:"throttle,omitempty"`
+	CreatedAt    string        `json:"created_at"`
+	UpdatedAt    string        `json:"updated_at"`
+}
+
+var errRuleNotFound = errors.New("rule not found")
+
+// Matches reports whether an alert with the given status and labels satisfies m.
+func (m Matcher) Matches(status string, labels map[string]string) bool {
+	return m.mismatch(status, labels) == ""
+}
+
+// mismatch explains why an alert does not satisfy m, or returns "" if it does.
+func (m Matcher) mismatch(status string, labels map[string]string) string {
+	if m.Status != "" && m.Status != status {
+		return fmt.Sprintf("status %q, want %q", status, m.Status)
+	}
+	fixed := []struct{ name, want string }{{"alertname", m.Alertname}, {"severity", m.Severity}, {"service", m.Service}}
+	for _, f := range fixed {
+		if f.want != "" && labels[f.name] != f.want {
+			return fmt.Sprintf("label %s=%q, want %q", f.name, labels[f.name], f.want)
+		}
+	}
+	keys := make([]string, 0, len(m.Labels))
+	for k := range m.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if labels[k] != m.Labels[k] {
+			return fmt.Sprintf("label %s=%q, want %q", k, labels[k], m.Labels[k])
+		}
+	}
+	return ""
+}
+
+func (r *Rule) validate() error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	switch r.Match.Status {
+	case "", "firing", "resolved":
+	default:
+		return fmt.Errorf("match.status must be %s, %s or empty, got %q", events.StatusFiring, events.StatusResolved, r.Match.Status)
+	}
+	if len(r.Actions) == 0 && !r.OpenIncident {
+		return errors.New("rule must open an incident or have at least one action")
+	}
+	if err := validateActions("actions", r.Name, r.Actions); err != nil {
+		return err
+	}
+	if r.Throttle != nil {
+		if err := r.Throttle.validate(); err != nil {
+			return err
+		}
+	}
+	if c := r.Compensation; c != nil {
+		if len(c.Actions) == 0 {
+			return errors.New("compensation.actions must not be empty")
+		}
+		if c.MaxAttempts < 0 || c.MaxAttempts > 10 {
+			return fmt.Errorf("compensation.max_attempts must be 0..10, got %d", c.MaxAttempts)
+		}
+		if err := validateActions("compensation.actions", r.Name, c.Actions); err != nil {
+			return err
+		}
+		// Compensation runs on action.failed, which carries no alert labels
+		for i, a := range c.Actions {
+			if a.Target != nil && a.Target.usesAlertLabels() {
+				return fmt.Errorf("compensation.actions[%d]: target cannot reference alert labels", i)
+			}
+			if a.Scaling != nil {
+				return fmt.Errorf("compensation.actions[%d]: scaling needs an alert, set params.desired_replicas", i)
+			}
+		}
+	}
+	return nil
+}
+
+func validateActions(field, rule string, actions []RuleAction) error {
+	for i, a := range actions {
+		if strings.TrimSpace(a.Kind) == "" {
+			return fmt.Errorf("%s[%d].kind is required", field, i)
+		}
+		if v, ok := a.Params["desired_replicas"]; ok {
+			if f, ok := v.(float64); !ok || f != float64(int(f)) {
+				return fmt.Errorf("%s[%d].params.desired_replicas must be an integer", field, i)
+			}
+		}
+		if a.Scaling != nil {
+			if a.Kind != events.KindScaleDocker {
+				return fmt.Errorf("%s[%d]: scaling is only supported for %s", field, i, events.KindScaleDocker)
+			}
+			if _, ok := a.Params["desired_replicas"]; ok {
+				return fmt.Errorf("%s[%d]: params.desired_replicas and scaling are mutually exclusive", field, i)
+			}
+			if err := a.Scaling.validate(); err != nil {
+				return fmt.Errorf("%s[%d]: %w", field, i, err)
+			}
+		}
+		if a.Target != nil {
+			if a.Kind != events.KindScaleDocker {
+				return fmt.Errorf("%s[%d]: target is only supported for %s", field, i, events.KindScaleDocker)
+			}
+			if err := a.Target.validate(); err != nil {
+				return fmt.Errorf("%s[%d]: %w", field, i, err)
+			}
+		}
+		req := a.request("", rule)
+		if a.Scaling != nil {
+			// Computed when the alert arrives
+			req.DesiredReplicas = 1
+		}
+		if err := req.Validate(); err != nil {
+			return fmt.Errorf("%s[%d]: %w", field, i, err)
+		}
+	}
+	return nil
+}
+
+// request builds the action.requested event for a matched alert. Parameters
+// with a dedicated event field are lifted out of Params. The target is set by
+// the caller, see TargetSpec.resolve.
+func (a RuleAction) request(alertFP, rule string) events.ActionRequested {
+	req := events.NewActionRequested(alertFP, a.Kind)
+	req.Rule = rule
+	for k, v := range a.Params {
+		switch k {
+		case "desired_replicas":
+			if f, ok := v.(float64); ok {
+				req.DesiredReplicas = int(f)
+			}
+		case "target_runner":
+			req.TargetRunner, _ = v.(string)
+		case "timeout_seconds":
+			if f, ok := v.(float64); ok {
+				req.TimeoutSeconds = int(f)
+			}
+		default:
+			if req.Params == nil {
+				req.Params = map[string]any{}
+			}
+			req.Params[k] = v
+		}
+	}
+	return req
+}
+
+const ruleColumns = `id, name, enabled, shadow, priority, match, open_incident, actions, COALESCE(compensation,''), COALESCE(throttle,''), created_at, updated_at`
+
+func scanRule(sc interface{ Scan(...any) error }) (Rule, error) {
+	var r Rule
+	var match, actions, compensation, throttle string
+	if err := sc.Scan(&r.ID, &r.Name, &r.Enabled, &r.Shadow, &r.Priority, &match, &r.OpenIncident, &actions, &compensation, &throttle, &r.CreatedAt, &r.UpdatedAt); err != nil {
+		return r, err
+	}
+	if throttle != "" {
+		if err := json.Unmarshal([]byte(throttle), &r.Throttle); err != nil {
+			return r, fmt.Errorf("rule %d: bad throttle: %w", r.ID, err)
+		}
+	}
+	if compensation != "" {
+		if err := json.Unmarshal([]byte(compensation), &r.Compensation); err != nil {
+			return r, fmt.Errorf("rule %d: bad compensation: %w", r.ID, err)
+		}
+	}
+	if err := json.Unmarshal([]byte(match), &r.Match); err != nil {
+		return r, fmt.Errorf("rule %d: bad match: %w", r.ID, err)
+	}
+	if err := json.Unmarshal([]byte(actions), &r.Actions); err != nil {
+		return r, fmt.Errorf("rule %d: bad actions: %w", r.ID, err)
+	}
+	return r, nil
+}
+
+func loadRules(db *sql.DB, enabledOnly bool) ([]Rule, error) {
+	q := `SELECT ` + ruleColumns + ` FROM rules`
+	if enabledOnly {
+		q += ` WHERE enabled`
+	}
+	q += ` ORDER BY priority DESC, id`
+	rows, err := db.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Rule{}
+	for rows.Next() {
+		r, err := scanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// getRuleByName returns the rule called name, enabled or not.
+func getRuleByName(db storage.Querier, name string) (Rule, error) {
+	r, err := scanRule(db.QueryRow(`SELECT `+ruleColumns+` FROM rules WHERE name=$1`, name))
+	if err == sql.ErrNoRows {
+		return r, errRuleNotFound
+	}
+	return r, err
+}
+
+// optionalJSON is the stored form of an optional rule part such as
+// compensation or throttle; NULL when the rule has none.
+func optionalJSON[T any](v *T) any {
+	if v == nil {
+		return nil
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func getRule(db *sql.DB, id int64) (Rule, error) {
+	r, err := scanRule(db.QueryRow(`SELECT `+ruleColumns+` FROM rules WHERE id=$1`, id))
+	if err == sql.ErrNoRows {
+		return r, errRuleNotFound
+	}
+	return r, err
+}
+
+func insertRule(db storage.Querier, r *Rule) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	match, _ := json.Marshal(r.Match)
+	actions, _ := json.Marshal(r.Actions)
+	r.CreatedAt, r.UpdatedAt = now, now
+	return db.QueryRow(`INSERT INTO rules (name, enabled, shadow, priority, match, open_incident, actions, compensation, throttle, created_at, updated_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$10) RETURNING id`,
+		r.Name, r.Enabled, r.Shadow, r.Priority, string(match), r.OpenIncident, string(actions), optionalJSON(r.Compensation), optionalJSON(r.Throttle), now).Scan(&r.ID)
+}
+
+func updateRule(db *sql.DB, r *Rule) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	match, _ := json.Marshal(r.Match)
+	actions, _ := json.Marshal(r.Actions)
+	err := db.QueryRow(`UPDATE rules SET name=$1, enabled=$2, shadow=$3, priority=$4, match=$5, open_incident=$6, actions=$7, compensation=$8, throttle=$9, updated_at=$10
+		WHERE id=$11 RETURNING created_at`,
+		r.Name, r.Enabled, r.Shadow, r.Priority, string(match), r.OpenIncident, string(actions), optionalJSON(r.Compensation), optionalJSON(r.Throttle), now, r.ID).Scan(&r.CreatedAt)
+	if err == sql.ErrNoRows {
+		return errRuleNotFound
+	}
+	r.UpdatedAt = now
+	return err
+}
+
+func setRuleEnabled(db *sql.DB, id int64, enabled bool) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	res, err := db.Exec(`UPDATE rules SET enabled=$1, updated_at=$2 WHERE id=$3`, enabled, now, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errRuleNotFound
+	}
+	return nil
+}
+
+func deleteRule(db *sql.DB, id int64) error {
+	res, err := db.Exec(`DELETE FROM rules WHERE id=$1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errRuleNotFound
+	}
+	return nil
+}
+
+// seedOnce runs apply in a transaction the first time the seed called name is
+// seen. Its marker row in rule_seeds keeps it from running again, even after
+// an operator deleted every rule it installed; concurrent rule engines wait
+// on the marker, so only one of them applies it.
+func seedOnce(db *sql.DB, name string, apply func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	res, err := tx.Exec(`INSERT INTO rule_seeds (name, applied_at) VALUES ($1,$2) ON CONFLICT (name) DO NOTHING`,
+		name, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	if err := apply(tx); err != nil {
+		return fmt.Errorf("seed %s: %w", name, err)
+	}
+	return tx.Commit()
+}
+
+// seedDefaultRules installs the built-in HighCPU behaviour (firing -> incident +
+// one replica more, compensated by converging back to 1 if that fails;
+// resolved -> one replica less, not earlier than 5 minutes after scaling up)
+// once per database. Replica counts stay within SERVICE_REPLICA_BOUNDS; both
+// rules suppress actions for a flapping alert.
+func seedDefaultRules(db *sql.DB) error {
+	return seedOnce(db, "default-rules", func(tx *sql.Tx) error {
+		// Databases from before the seed marker already hold the defaults or
+		// the operator's own rules
+		var n int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM rules`).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			return nil
+		}
+		for _, r := range defaultRules() {
+			if err := insertRule(tx, &r); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// migrateStepScaling switches default rules seeded before step scaling, which
+// requested a fixed 2 replicas on firing and 1 on resolved, to the current
+// step policies once per database. Rules whose actions were edited are kept.
+func migrateStepScaling(db *sql.DB) error {
+	fixed := func(n float64) []RuleAction {
+		return []RuleAction{{Kind: events.KindScaleDocker, Params: map[string]any{"desired_replicas": n}}}
+	}
+	seeded := map[string][]RuleAction{"scale-up-on-firing": fixed(2), "scale-down-on-resolved": fixed(1)}
+	return seedOnce(db, "default-rules-step-scaling", func(tx *sql.Tx) error {
+		now := time.Now().UTC().Format(time.RFC3339)
+		for _, def := range defaultRules() {
+			r, err := getRuleByName(tx, def.Name)
+			if errors.Is(err, errRuleNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if !reflect.DeepEqual(r.Actions, seeded[def.Name]) {
+				continue
+			}
+			actions, _ := json.Marshal(def.Actions)
+			if _, err := tx.Exec(`UPDATE rules SET actions=$1, updated_at=$2 WHERE id=$3`, string(actions), now, r.ID); err != nil {
+				return err
+			}
+			log.Printf("rule %s: fixed desired_replicas replaced by step scaling", r.Name)
+		}
+		return nil
+	})
+}
+
+// defaultRules are the rules seedDefaultRules installs.
+func defaultRules() []Rule {
+	return []Rule{
+		{
+			Name:         "scale-up-on-firing",
+			Enabled:      true,
+			Match:        Matcher{Status: "firing"},
+			OpenIncident: true,
+			Actions:      []RuleAction{{Kind: events.KindScaleDocker, Scaling: &ScalingPolicy{Mode: scaleStep, Step: 1}}},
+			Compensation: &Compensation{
+				Actions:     []RuleAction{{Kind: events.KindScaleDocker, Params: map[string]any{"desired_replicas": float64(1)}}},
+				MaxAttempts: 2,
+			},
+			Throttle: &Throttle{Cooldown: 2 * time.Minute, FlapCount: 6, FlapWindow: 30 * time.Minute},
+		},
+		{
+			Name:     "scale-down-on-resolved",
+			Enabled:  true,
+			Match:    Matcher{Status: "resolved"},
+			Actions:  []RuleAction{{Kind: events.KindScaleDocker, Scaling: &ScalingPolicy{Mode: scaleStep, Step: -1}}},
+			Throttle: &Throttle{Cooldown: 2 * time.Minute, MinDwell: 5 * time.Minute, FlapCount: 6, FlapWindow: 30 * time.Minute},
+		},
+	}
+}
+
+func isUniqueViolation(err error) bool {
+	s := strings.ToLower(err.Error())
+	return strings.Contains(s, "unique") || strings.Contains(s, "duplicate")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeRuleError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errRuleNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case isUniqueViolation(err):
+		http.Error(w, "rule name already exists", http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (re *RuleEngine) decodeRule(r *http.Request) (Rule, error) {
+	rule := Rule{Enabled: true}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rule); err != nil {
+		return rule, fmt.Errorf("invalid rule: %w", err)
+	}
+	return rule, re.checkRule(&rule)
+}
+
+// checkRule validates a rule and checks its action kinds against the runners.
+func (re *RuleEngine) checkRule(rule *Rule) error {
+	if err := rule.validate(); err != nil {
+		return err
+	}
+	if rule.Compensation != nil {
+		if err := re.kinds.check(rule.Compensation.Actions); err != nil {
+			return fmt.Errorf("compensation: %w", err)
+		}
+	}
+	return re.kinds.check(rule.Actions)
+}
+
+// handleRules serves GET /rules (list) and POST /rules (create).
+func (re *RuleEngine) handleRules(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		rules, err := loadRules(re.db, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, rules)
+	case http.MethodPost:
+		rule, err := re.decodeRule(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := insertRule(re.db, &rule); err != nil {
+			writeRuleError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, rule)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRule serves /rules/{id} (GET, PUT, DELETE) and
+// POST /rules/{id}/enable, POST /rules/{id}/disable.
+func (re *RuleEngine) handleRule(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/rules/"), "/")
+	parts := strings.Split(rest, "/")
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid rule id", http.StatusBadRequest)
+		return
+	}
+	if len(parts) == 2 {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var enabled bool
+		switch parts[1] {
+		case "enable":
+			enabled = true
+		case "disable":
+			enabled = false
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		if err := setRuleEnabled(re.db, id, enabled); err != nil {
+			writeRuleError(w, err)
+			return
+		}
+		rule, err := getRule(re.db, id)
+		if err != nil {
+			writeRuleError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, rule)
+		return
+	}
+	if len(parts) > 2 {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		rule, err := getRule(re.db, id)
+		if err != nil {
+			writeRuleError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, rule)
+	case http.MethodPut:
+		rule, err := re.decodeRule(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rule.ID = id
+		if err := updateRule(re.db, &rule); err != nil {
+			writeRuleError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, rule)
+	case http.MethodDelete:
+		if err := deleteRule(re.db, id); err != nil {
+			writeRuleError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
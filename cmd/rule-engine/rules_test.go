@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ilya2309548/EventPulse/internal/events"
+)
+
+func TestMatcherMismatch(t *testing.T) {
+	labels := map[string]string{"alertname": "HighCPU", "severity": "critical", "service": "app", "env": "prod", "team": "core"}
+	tests := []struct {
+		name   string
+		m      Matcher
+		status string
+		labels map[string]string
+		want   string
+	}{
+		{"empty matcher matches anything", Matcher{}, "firing", nil, ""},
+		{"all fields match", Matcher{Status: "firing", Alertname: "HighCPU", Severity: "critical", Service: "app", Labels: map[string]string{"env": "prod"}}, "firing", labels, ""},
+		{"status", Matcher{Status: "firing"}, "resolved", labels, `status "resolved", want "firing"`},
+		{"alertname", Matcher{Alertname: "HighMemory"}, "firing", labels, `label alertname="HighCPU", want "HighMemory"`},
+		{"severity", Matcher{Severity: "warning"}, "firing", labels, `label severity="critical", want "warning"`},
+		{"service", Matcher{Service: "api"}, "firing", labels, `label service="app", want "api"`},
+		{"missing label", Matcher{Service: "app"}, "firing", map[string]string{}, `label service="", want "app"`},
+		{"status is checked first", Matcher{Status: "resolved", Severity: "warning"}, "firing", labels, `status "firing", want "resolved"`},
+		{"fixed labels before label map", Matcher{Severity: "warning", Labels: map[string]string{"env": "dev"}}, "firing", labels, `label severity="critical", want "warning"`},
+		{"label map in key order", Matcher{Labels: map[string]string{"team": "infra", "env": "dev"}}, "firing", labels, `label env="prod", want "dev"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.m.mismatch(tt.status, tt.labels); got != tt.want {
+				t.Errorf("mismatch = %q, want %q", got, tt.want)
+			}
+			if got := tt.m.Matches(tt.status, tt.labels); got != (tt.want == "") {
+				t.Errorf("Matches = %v, want %v", got, tt.want == "")
+			}
+		})
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	scale := func(params map[string]any) RuleAction {
+		return RuleAction{Kind: events.KindScaleDocker, Params: params}
+	}
+	tests := []struct {
+		name    string
+		rule    Rule
+		wantErr string
+	}{
+		{"valid", Rule{Name: "r", Match: Matcher{Status: "firing"}, Actions: []RuleAction{scale(map[string]any{"desired_replicas": float64(2)})}}, ""},
+		{"incident only", Rule{Name: "r", OpenIncident: true}, ""},
+		{"name required", Rule{Name: "  ", OpenIncident: true}, "name is required"},
+		{"bad status", Rule{Name: "r", Match: Matcher{Status: "pending"}, OpenIncident: true}, "match.status must be"},
+		{"nothing to do", Rule{Name: "r"}, "must open an incident or have at least one action"},
+		{"kind required", Rule{Name: "r", Actions: []RuleAction{{}}}, "actions[0].kind is required"},
+		{"fractional replicas", Rule{Name: "r", Actions: []RuleAction{scale(map[string]any{"desired_replicas": 1.5})}}, "must be an integer"},
+		{"scaling and fixed replicas", Rule{Name: "r", Actions: []RuleAction{{Kind: events.KindScaleDocker, Params: map[string]any{"desired_replicas": float64(2)}, Scaling: &ScalingPolicy{Mode: scaleStep, Step: 1}}}}, "mutually exclusive"},
+		{"scaling on another kind", Rule{Name: "r", Actions: []RuleAction{{Kind: events.KindRestartRunner, Scaling: &ScalingPolicy{Mode: scaleStep, Step: 1}}}}, "scaling is only supported for"},
+		{"empty compensation", Rule{Name: "r", OpenIncident: true, Compensation: &Compensation{}}, "compensation.actions must not be empty"},
+		{"compensation attempts", Rule{Name: "r", OpenIncident: true, Compensation: &Compensation{Actions: []RuleAction{scale(map[string]any{"desired_replicas": float64(1)})}, MaxAttempts: 11}}, "compensation.max_attempts must be 0..10"},
+		{"compensation scaling", Rule{Name: "r", OpenIncident: true, Compensation: &Compensation{Actions: []RuleAction{{Kind: events.KindScaleDocker, Scaling: &ScalingPolicy{Mode: scaleStep, Step: -1}}}}}, "scaling needs an alert"},
+		{"compensation target from alert", Rule{Name: "r", OpenIncident: true, Compensation: &Compensation{Actions: []RuleAction{{Kind: events.KindScaleDocker, Params: map[string]any{"desired_replicas": float64(1)}, Target: &TargetSpec{ServiceFrom: "service"}}}}}, "cannot reference alert labels"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rule.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDefaultRulesAreValid(t *testing.T) {
+	for _, r := range defaultRules() {
+		if err := r.validate(); err != nil {
+			t.Errorf("default rule %s: %v", r.Name, err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ilya2309548/EventPulse/internal/events"
+)
+
+type ruleTestRequest struct {
+	// Alert is a sample alert.raised payload; type, version and dedup_key may be omitted.
+	Alert json.RawMessage `json:"alert,omitempty"`
+	// AlertFP selects the last alert received with this fingerprint instead.
+	AlertFP string `json:"alert_fp,omitempty"`
+	// Rules, when set, are evaluated instead of the stored rules, e.g. to try
+	// a rule before saving it.
+	Rules []json.RawMessage `json:"rules,omitempty"`
+}
+
+type testEvent struct {
+	Type  string `json:"type"`
+	Event any    `json:"event"`
+}
+
+// handleRuleTest serves POST /rules/test: a dry run of the rules against a
+// sample or stored alert. It returns the decisions and the exact events that
+// would be emitted; nothing is published, and the inbox, outbox, throttle
+// state and decisions_log are left untouched because the evaluation runs in a
+// transaction that is rolled back.
+func (re *RuleEngine) handleRuleTest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body ruleTestRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	alert, err := re.testAlert(body)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "no alert received with fingerprint "+body.AlertFP, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var rules []Rule
+	if body.Rules != nil {
+		for i, raw := range body.Rules {
+			rule := Rule{Enabled: true}
+			d := json.NewDecoder(bytes.NewReader(raw))
+			d.DisallowUnknownFields()
+			err := d.Decode(&rule)
+			if err == nil {
+				err = re.checkRule(&rule)
+			}
+			if err != nil {
+				http.Error(w, fmt.Sprintf("rules[%d]: %v", i, err), http.StatusBadRequest)
+				return
+			}
+			rules = append(rules, rule)
+		}
+	} else if rules, err = loadRules(re.db, false); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ev, err := re.plan(r.Context(), rules, alert)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tx, err := re.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+	if err := re.apply(tx, ev, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out := []testEvent{}
+	for _, m := range ev.msgs {
+		out = append(out, testEvent{Type: m.typ, Event: m.body})
+	}
+	names := []string{}
+	for _, rule := range ev.matched {
+		names = append(names, rule.Name)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"alert":     alert,
+		"matched":   names,
+		"events":    out,
+		"decisions": ev.decisions(),
+	})
+}
+
+// testAlert returns the alert to evaluate: the sample from the request, or
+// the last one stored for AlertFP.
+func (re *RuleEngine) testAlert(body ruleTestRequest) (events.AlertRaised, error) {
+	var alert events.AlertRaised
+	switch {
+	case len(body.Alert) > 0 && body.AlertFP != "":
+		return alert, errors.New("alert and alert_fp are mutually exclusive")
+	case body.AlertFP != "":
+		var payload string
+		if err := re.db.QueryRow(`SELECT payload FROM last_alerts WHERE alert_fp=$1`, body.AlertFP).Scan(&payload); err != nil {
+			return alert, err
+		}
+		return events.DecodeAlertRaised([]byte(payload))
+	case len(body.Alert) > 0:
+		if err := json.Unmarshal(body.Alert, &alert); err != nil {
+			return alert, fmt.Errorf("invalid alert: %w", err)
+		}
+		if alert.Type == "" {
+			alert.Type = events.TypeAlertRaised
+		}
+		if alert.Version == 0 {
+			alert.Version = 1
+		}
+		if alert.DedupKey == "" {
+			alert.DedupKey = "test:" + alert.Fingerprint
+		}
+		return alert, alert.Validate()
+	}
+	return alert, errors.New("alert or alert_fp is required")
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ilya2309548/EventPulse/internal/events"
+)
+
+// Scaling policy modes.
+const (
+	scaleStep     = "step"
+	scaleMultiply = "multiply"
+	scaleTrack    = "target_tracking"
+)
+
+// ScalingPolicy computes desired_replicas of a scale_docker action from the
+// target's current replica count instead of a fixed value:
+//
+//	step:            current + Step (negative to scale down)
+//	multiply:        ceil(current * Factor)
+//	target_tracking: ceil(current * value / Setpoint), where value is the
+//	                 alert annotation (or label) named by Metric
+//
+// The result is clamped to the action target's min/max replicas and to the
+// service's bounds (SERVICE_REPLICA_BOUNDS).
+type ScalingPolicy struct {
+	Mode     string  `json:"mode"`
+	Step     int     `json:"step,omitempty"`
+	Factor   float64 `json:"factor,omitempty"`
+	Metric   string  `json:"metric,omitempty"`
+	Setpoint float64 `json:"setpoint,omitempty"`
+}
+
+func (p ScalingPolicy) validate() error {
+	switch p.Mode {
+	case scaleStep:
+		if p.Step == 0 {
+			return errors.New("scaling.step must not be 0")
+		}
+	case scaleMultiply:
+		if p.Factor <= 0 || p.Factor == 1 {
+			return fmt.Errorf("scaling.factor must be > 0 and != 1, got %v", p.Factor)
+		}
+	case scaleTrack:
+		if strings.TrimSpace(p.Metric) == "" {
+			return errors.New("scaling.metric is required for target_tracking")
+		}
+		if p.Setpoint <= 0 {
+			return fmt.Errorf("scaling.setpoint must be > 0, got %v", p.Setpoint)
+		}
+	default:
+		return fmt.Errorf("scaling.mode must be %s, %s or %s, got %q", scaleStep, scaleMultiply, scaleTrack, p.Mode)
+	}
+	return nil
+}
+
+// metricValue reads the tracked metric from the alert's annotations, then labels.
+func metricValue(alert events.AlertRaised, name string) (float64, error) {
+	raw, ok := alert.Annotations[name]
+	if !ok {
+		raw, ok = alert.Labels[name]
+	}
+	if !ok {
+		return 0, fmt.Errorf("alert has no annotation or label %q", name)
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+	if err != nil {
+		return 0, fmt.Errorf("metric %s=%q is not a number", name, raw)
+	}
+	return v, nil
+}
+
+// compute returns the unclamped desired replica count; inputs records what it
+// was computed from.
+func (p ScalingPolicy) compute(current int, alert events.AlertRaised, inputs map[string]any) (int, error) {
+	inputs["mode"] = p.Mode
+	switch p.Mode {
+	case scaleStep:
+		inputs["step"] = p.Step
+		return current + p.Step, nil
+	case scaleMultiply:
+		inputs["factor"] = p.Factor
+		return int(math.Ceil(float64(current) * p.Factor)), nil
+	case scaleTrack:
+		v, err := metricValue(alert, p.Metric)
+		if err != nil {
+			return 0, err
+		}
+		inputs["metric"], inputs["value"], inputs["setpoint"] = p.Metric, v, p.Setpoint
+		// Keep at least one replica as the base, so tracking can scale up from zero
+		base := current
+		if base < 1 {
+			base = 1
+		}
+		return int(math.Ceil(float64(base) * v / p.Setpoint)), nil
+	}
+	return 0, fmt.Errorf("unknown scaling mode %q", p.Mode)
+}
+
+// replicaBounds are the min/max replicas per service; "*" applies to services
+// without their own entry.
+type replicaBounds map[string][2]int
+
+// parseReplicaBounds reads "app=1:4,api=2:10,*=1:10".
+func parseReplicaBounds(s string) (replicaBounds, error) {
+	b := replicaBounds{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		svc, rng, ok := strings.Cut(part, "=")
+		lo, hi, ok2 := strings.Cut(rng, ":")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("bad bounds %q, want service=min:max", part)
+		}
+		min, err1 := strconv.Atoi(lo)
+		max, err2 := strconv.Atoi(hi)
+		if err1 != nil || err2 != nil || min < 1 || max < min {
+			return nil, fmt.Errorf("bad bounds %q, want 1 <= min <= max", part)
+		}
+		b[strings.TrimSpace(svc)] = [2]int{min, max}
+	}
+	return b, nil
+}
+
+// clamp keeps n within the bounds of the target (scale:<service>).
+func (b replicaBounds) clamp(target string, n int) (int, [2]int) {
+	bounds, ok := b[strings.TrimPrefix(target, "scale:")]
+	if !ok {
+		bounds, ok = b["*"]
+	}
+	if !ok {
+		bounds = [2]int{1, math.MaxInt32}
+	}
+	if n < bounds[0] {
+		n = bounds[0]
+	}
+	if n > bounds[1] {
+		n = bounds[1]
+	}
+	return n, bounds
+}
+
+// replicaSource asks the action runners how many replicas a target runs.
+type replicaSource struct {
+	urls   []string
+	client *http.Client
+}
+
+func newReplicaSource(urls []string) *replicaSource {
+	return &replicaSource{urls: urls, client: &http.Client{Timeout: 2 * time.Second}}
+}
+
+// count asks each runner in turn; the first answer wins.
+func (s *replicaSource) count(ctx context.Context, req events.ActionRequested) (int, error) {
+	q := url.Values{}
+	if req.Target != nil {
+		if req.Target.Service != "" {
+			q.Set("service", req.Target.Service)
+		}
+		keys := make([]string, 0, len(req.Target.Labels))
+		for k := range req.Target.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			q.Add("label", k+"="+req.Target.Labels[k])
+		}
+	} else if svc, ok := req.Params["service"].(string); ok && svc != "" {
+		q.Set("service", svc)
+	}
+	var lastErr error = errors.New("no action runner configured")
+	for _, u := range s.urls {
+		hreq, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(u, "/")+"/replicas?"+q.Encode(), nil)
+		if err != nil {
+			return 0, err
+		}
+		resp, err := s.client.Do(hreq)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		var body struct {
+			Replicas int `json:"replicas"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			lastErr = fmt.Errorf("%s/replicas: %s", u, resp.Status)
+			continue
+		}
+		if err != nil {
+			lastErr = fmt.Errorf("%s/replicas: %w", u, err)
+			continue
+		}
+		return body.Replicas, nil
+	}
+	return 0, lastErr
+}
+
+// currentReplicas returns the target's replica count from the runners, or
+// else the last desired count the rule engine requested for it.
+func (re *RuleEngine) currentReplicas(ctx context.Context, req events.ActionRequested) (int, string, error) {
+	n, err := re.replicas.count(ctx, req)
+	if err == nil {
+		return n, "runner", nil
+	}
+	var last sql.NullInt64
+	qerr := re.db.QueryRowContext(ctx, `SELECT last_desired FROM scaling_state WHERE target=$1`, targetKey(req)).Scan(&last)
+	if qerr == nil && last.Valid {
+		return int(last.Int64), "last_desired", nil
+	}
+	return 0, "", fmt.Errorf("current replicas unknown: %w", err)
+}
+
+// applyScaling sets req.DesiredReplicas from the policy. The decision records
+// the inputs and the result; ok is false when no action should be emitted
+// (unknown inputs, or the target is already at the desired count).
+func (re *RuleEngine) applyScaling(ctx context.Context, p ScalingPolicy, req *events.ActionRequested, alert events.AlertRaised) (decision, bool) {
+	target := targetKey(*req)
+	inputs := map[string]any{"target": target}
+	d := decision{Kind: decisionScaling, AlertFP: alert.Fingerprint, Rule: req.Rule, Details: inputs}
+	current, source, err := re.currentReplicas(ctx, *req)
+	if err != nil {
+		d.Outcome, d.Reason = outcomeSkipped, err.Error()
+		return d, false
+	}
+	inputs["current"], inputs["current_source"] = current, source
+	raw, err := p.compute(current, alert, inputs)
+	if err != nil {
+		d.Outcome, d.Reason = outcomeSkipped, err.Error()
+		return d, false
+	}
+	inputs["computed"] = raw
+	desired := raw
+	if t := req.Target; t != nil {
+		if t.MinReplicas > 0 && desired < t.MinReplicas {
+			desired = t.MinReplicas
+		}
+		if t.MaxReplicas > 0 && desired > t.MaxReplicas {
+			desired = t.MaxReplicas
+		}
+	}
+	desired, bounds := re.bounds.clamp(target, desired)
+	inputs["min_replicas"], inputs["max_replicas"], inputs["desired"] = bounds[0], bounds[1], desired
+	if desired == current {
+		d.Outcome, d.Reason = outcomeSkipped, fmt.Sprintf("already at %d replica(s)", current)
+		return d, false
+	}
+	req.DesiredReplicas = desired
+	d.Outcome, d.Events = outcomeEmitted, []string{req.ActionID}
+	return d, true
+}
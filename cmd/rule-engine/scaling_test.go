@@ -0,0 +1,157 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ilya2309548/EventPulse/internal/events"
+)
+
+func TestScalingPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		p       ScalingPolicy
+		wantErr string
+	}{
+		{"step up", ScalingPolicy{Mode: scaleStep, Step: 1}, ""},
+		{"step down", ScalingPolicy{Mode: scaleStep, Step: -2}, ""},
+		{"zero step", ScalingPolicy{Mode: scaleStep}, "scaling.step must not be 0"},
+		{"multiply", ScalingPolicy{Mode: scaleMultiply, Factor: 1.5}, ""},
+		{"shrink", ScalingPolicy{Mode: scaleMultiply, Factor: 0.5}, ""},
+		{"factor of one", ScalingPolicy{Mode: scaleMultiply, Factor: 1}, "scaling.factor must be > 0 and != 1"},
+		{"negative factor", ScalingPolicy{Mode: scaleMultiply, Factor: -2}, "scaling.factor must be > 0 and != 1"},
+		{"tracking", ScalingPolicy{Mode: scaleTrack, Metric: "cpu", Setpoint: 70}, ""},
+		{"tracking without metric", ScalingPolicy{Mode: scaleTrack, Metric: " ", Setpoint: 70}, "scaling.metric is required"},
+		{"tracking without setpoint", ScalingPolicy{Mode: scaleTrack, Metric: "cpu"}, "scaling.setpoint must be > 0"},
+		{"unknown mode", ScalingPolicy{Mode: "linear", Step: 1}, `got "linear"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.p.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScalingPolicyCompute(t *testing.T) {
+	alert := events.NewAlertRaised("fp", events.StatusFiring, "ep")
+	alert.Annotations = map[string]string{"cpu": " 140 ", "bad": "high"}
+	alert.Labels = map[string]string{"cpu": "10", "queue": "35"}
+	tests := []struct {
+		name       string
+		p          ScalingPolicy
+		current    int
+		want       int
+		wantErr    string
+		wantInputs map[string]any
+	}{
+		{"step up", ScalingPolicy{Mode: scaleStep, Step: 1}, 2, 3, "", map[string]any{"mode": scaleStep, "step": 1}},
+		{"step below zero is left to clamp", ScalingPolicy{Mode: scaleStep, Step: -2}, 1, -1, "", nil},
+		{"multiply rounds up", ScalingPolicy{Mode: scaleMultiply, Factor: 1.5}, 3, 5, "", map[string]any{"mode": scaleMultiply, "factor": 1.5}},
+		{"shrink rounds up", ScalingPolicy{Mode: scaleMultiply, Factor: 0.5}, 3, 2, "", nil},
+		{
+			"tracking prefers annotations", ScalingPolicy{Mode: scaleTrack, Metric: "cpu", Setpoint: 70}, 2, 4, "",
+			map[string]any{"mode": scaleTrack, "metric": "cpu", "value": 140.0, "setpoint": 70.0},
+		},
+		{"tracking falls back to labels", ScalingPolicy{Mode: scaleTrack, Metric: "queue", Setpoint: 10}, 1, 4, "", nil},
+		{"tracking from zero replicas", ScalingPolicy{Mode: scaleTrack, Metric: "cpu", Setpoint: 70}, 0, 2, "", nil},
+		{"tracking below setpoint", ScalingPolicy{Mode: scaleTrack, Metric: "queue", Setpoint: 70}, 4, 2, "", nil},
+		{"missing metric", ScalingPolicy{Mode: scaleTrack, Metric: "mem", Setpoint: 70}, 2, 0, `alert has no annotation or label "mem"`, nil},
+		{"metric not a number", ScalingPolicy{Mode: scaleTrack, Metric: "bad", Setpoint: 70}, 2, 0, `metric bad="high" is not a number`, nil},
+		{"unknown mode", ScalingPolicy{Mode: "linear"}, 2, 0, `unknown scaling mode "linear"`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inputs := map[string]any{}
+			got, err := tt.p.compute(tt.current, alert, inputs)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("compute(%d) = %d, want %d", tt.current, got, tt.want)
+			}
+			if tt.wantInputs != nil && !reflect.DeepEqual(inputs, tt.wantInputs) {
+				t.Errorf("inputs = %v, want %v", inputs, tt.wantInputs)
+			}
+		})
+	}
+}
+
+func TestParseReplicaBounds(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    replicaBounds
+		wantErr string
+	}{
+		{"empty", "", replicaBounds{}, ""},
+		{"services and default", " app=1:4, api=2:10 ,*=1:10,", replicaBounds{"app": {1, 4}, "api": {2, 10}, "*": {1, 10}}, ""},
+		{"single replica", "app=1:1", replicaBounds{"app": {1, 1}}, ""},
+		{"no range", "app", nil, "want service=min:max"},
+		{"no colon", "app=4", nil, "want service=min:max"},
+		{"not a number", "app=one:4", nil, "want 1 <= min <= max"},
+		{"zero min", "app=0:4", nil, "want 1 <= min <= max"},
+		{"inverted", "app=4:2", nil, "want 1 <= min <= max"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseReplicaBounds(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("bounds = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplicaBoundsClamp(t *testing.T) {
+	b := replicaBounds{"app": {1, 4}, "*": {2, 10}}
+	tests := []struct {
+		name       string
+		b          replicaBounds
+		target     string
+		n          int
+		want       int
+		wantBounds [2]int
+	}{
+		{"within", b, "scale:app", 3, 3, [2]int{1, 4}},
+		{"above max", b, "scale:app", 7, 4, [2]int{1, 4}},
+		{"below min", b, "scale:app", -1, 1, [2]int{1, 4}},
+		{"default entry", b, "scale:api", 1, 2, [2]int{2, 10}},
+		{"label target uses the default", b, "scale:tier=web", 12, 10, [2]int{2, 10}},
+		{"no bounds keeps one replica", replicaBounds{}, "scale:app", 0, 1, [2]int{1, math.MaxInt32}},
+		{"no bounds no upper limit", replicaBounds{}, "scale:app", 50, 50, [2]int{1, math.MaxInt32}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, bounds := tt.b.clamp(tt.target, tt.n)
+			if got != tt.want || bounds != tt.wantBounds {
+				t.Errorf("clamp(%q, %d) = %d %v, want %d %v", tt.target, tt.n, got, bounds, tt.want, tt.wantBounds)
+			}
+		})
+	}
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ilya2309548/EventPulse/internal/events"
+	"github.com/ilya2309548/EventPulse/internal/storage"
+)
+
+// Throttle limits how often a rule's actions change the same target:
+//
+//   - Cooldown: minimum time between two actions on the target.
+//   - MinDwell: minimum time after a scale-up before the target is scaled down.
+//   - FlapCount/FlapWindow: an alert that changed status FlapCount times
+//     within FlapWindow is flapping; its actions are suppressed for
+//     FlapSuppress (default FlapWindow) and the incident gets a note.
+//
+// An action that may not run yet is deferred rather than dropped: it is
+// emitted once the target is allowed to change, unless a newer action for the
+// same target replaces it first.
+type Throttle struct {
+	Cooldown     time.Duration
+	MinDwell     time.Duration
+	FlapCount    int
+	FlapWindow   time.Duration
+	FlapSuppress time.Duration
+}
+
+type throttleJSON struct {
+	Cooldown     string `json:"cooldown,omitempty"`
+	MinDwell     string `json:"min_dwell,omitempty"`
+	FlapCount    int    `json:"flap_count,omitempty"`
+	FlapWindow   string `json:"flap_window,omitempty"`
+	FlapSuppress string `json:"flap_suppress,omitempty"`
+}
+
+// MarshalJSON writes durations as strings ("2m").
+func (t Throttle) MarshalJSON() ([]byte, error) {
+	str := func(d time.Duration) string {
+		if d == 0 {
+			return ""
+		}
+		return d.String()
+	}
+	return json.Marshal(throttleJSON{Cooldown: str(t.Cooldown), MinDwell: str(t.MinDwell), FlapCount: t.FlapCount,
+		FlapWindow: str(t.FlapWindow), FlapSuppress: str(t.FlapSuppress)})
+}
+
+// UnmarshalJSON reads durations written as strings ("2m").
+func (t *Throttle) UnmarshalJSON(b []byte) error {
+	var v throttleJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*t = Throttle{FlapCount: v.FlapCount}
+	for _, d := range []struct {
+		name string
+		src  string
+		dst  *time.Duration
+	}{{"cooldown", v.Cooldown, &t.Cooldown}, {"min_dwell", v.MinDwell, &t.MinDwell}, {"flap_window", v.FlapWindow, &t.FlapWindow}, {"flap_suppress", v.FlapSuppress, &t.FlapSuppress}} {
+		if d.src == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.src)
+		if err != nil {
+			return fmt.Errorf("throttle.%s: %w", d.name, err)
+		}
+		*d.dst = parsed
+	}
+	return nil
+}
+
+func (t Throttle) validate() error {
+	if t.Cooldown < 0 || t.MinDwell < 0 || t.FlapWindow < 0 || t.FlapSuppress < 0 {
+		return errors.New("throttle: durations must be >= 0")
+	}
+	if t.FlapCount < 0 || t.FlapCount == 1 {
+		return fmt.Errorf("throttle.flap_count must be 0 (off) or >= 2, got %d", t.FlapCount)
+	}
+	if t.FlapCount > 0 && t.FlapWindow == 0 {
+		return errors.New("throttle.flap_window is required with flap_count")
+	}
+	return nil
+}
+
+func (t Throttle) suppressFor() time.Duration {
+	if t.FlapSuppress > 0 {
+		return t.FlapSuppress
+	}
+	return t.FlapWindow
+}
+
+// migrateThrottle creates the persisted throttle state, so cooldowns and
+// suppressions survive restarts.
+func migrateThrottle(db storage.Querier) error {
+	stmts := []string{
+		`ALTER TABLE rules ADD COLUMN IF NOT EXISTS throttle TEXT`,
+		`CREATE TABLE IF NOT EXISTS scaling_state (
+			target TEXT PRIMARY KEY,
+			last_action_id TEXT,
+			last_action_at TIMESTAMPTZ,
+			last_desired INTEGER,
+			last_scale_up_at TIMESTAMPTZ,
+			suppressed_until TIMESTAMPTZ,
+			updated_at TIMESTAMPTZ NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS alert_transitions (
+			id SERIAL PRIMARY KEY,
+			alert_fp TEXT NOT NULL,
+			status TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_alert_transitions_fp ON alert_transitions(alert_fp, created_at)`,
+		`CREATE TABLE IF NOT EXISTS deferred_actions (
+			target TEXT PRIMARY KEY,
+			action_id TEXT NOT NULL,
+			rule TEXT,
+			alert_fp TEXT,
+			payload TEXT NOT NULL,
+			reason TEXT NOT NULL,
+			due_at TIMESTAMPTZ NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL
+		)`,
+	}
+	for _, s := range stmts {
+		if _, err := db.Exec(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// targetKey names what an action changes; throttling is per target. It
+// matches the runner's lease keys.
+func targetKey(req events.ActionRequested) string {
+	switch {
+	case req.Kind == events.KindRestartRunner:
+		return "runner:" + req.TargetRunner
+	case req.Target != nil && req.Target.Service != "":
+		return "scale:" + req.Target.Service
+	case req.Target != nil && len(req.Target.Labels) > 0:
+		var parts []string
+		for k, v := range req.Target.Labels {
+			parts = append(parts, k+"="+v)
+		}
+		sort.Strings(parts)
+		return "scale:" + strings.Join(parts, ",")
+	}
+	if svc, ok := req.Params["service"].(string); ok && svc != "" {
+		return "scale:" + svc
+	}
+	return "scale:app"
+}
+
+// recordTransition remembers an alert status change for flap detection.
+func recordTransition(db storage.Querier, alertFP, status string, at time.Time) error {
+	_, err := db.Exec(`INSERT INTO alert_transitions (alert_fp, status, created_at) VALUES ($1,$2,$3)`, alertFP, status, at)
+	return err
+}
+
+// maxFlapWindow is the longest flap_window of rules, 0 if none detects
+// flapping.
+func maxFlapWindow(rules []Rule) time.Duration {
+	var max time.Duration
+	for _, r := range rules {
+		if r.Throttle != nil && r.Throttle.FlapCount > 0 && r.Throttle.FlapWindow > max {
+			max = r.Throttle.FlapWindow
+		}
+	}
+	return max
+}
+
+// pruneTransitions periodically removes alert transitions that are older than
+// every rule's flap_window, so flap detection never misses one it counts.
+func (re *RuleEngine) pruneTransitions(interval time.Duration) {
+	for {
+		n, window, err := re.pruneTransitionsOnce()
+		if err != nil {
+			log.Printf("transitions prune failed: %v", err)
+		} else if n > 0 {
+			log.Printf("transitions prune: removed %d transitions older than %s", n, window)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (re *RuleEngine) pruneTransitionsOnce() (int64, time.Duration, error) {
+	rules, err := loadRules(re.db, false)
+	if err != nil {
+		return 0, 0, err
+	}
+	window := maxFlapWindow(rules)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	res, err := re.db.ExecContext(ctx, `DELETE FROM alert_transitions WHERE created_at < $1`, time.Now().Add(-window))
+	if err != nil {
+		return 0, window, err
+	}
+	n, _ := res.RowsAffected()
+	return n, window, nil
+}
+
+type scalingState struct {
+	lastActionAt    sql.NullTime
+	lastDesired     sql.NullInt64
+	lastScaleUpAt   sql.NullTime
+	suppressedUntil sql.NullTime
+}
+
+// lockState returns the throttle state of target, locked for the rest of tx.
+func lockState(tx *sql.Tx, target string, now time.Time) (scalingState, error) {
+	var st scalingState
+	if _, err := tx.Exec(`INSERT INTO scaling_state (target, updated_at) VALUES ($1,$2) ON CONFLICT (target) DO NOTHING`, target, now); err != nil {
+		return st, err
+	}
+	err := tx.QueryRow(`SELECT last_action_at, last_desired, last_scale_up_at, suppressed_until FROM scaling_state WHERE target=$1 FOR UPDATE`, target).
+		Scan(&st.lastActionAt, &st.lastDesired, &st.lastScaleUpAt, &st.suppressedUntil)
+	return st, err
+}
+
+// scaleDirection is "up", "down" or "" for an action: compared with the last
+// desired replica count of the target, or from the alert status when the
+// target has no history.
+func scaleDirection(req events.ActionRequested, st scalingState, status string) string {
+	if req.Kind != events.KindScaleDocker {
+		return ""
+	}
+	if st.lastDesired.Valid {
+		switch {
+		case int64(req.DesiredReplicas) > st.lastDesired.Int64:
+			return "up"
+		case int64(req.DesiredReplicas) < st.lastDesired.Int64:
+			return "down"
+		}
+		return ""
+	}
+	if status == events.StatusResolved {
+		return "down"
+	}
+	return "up"
+}
+
+// decide applies t to an action in direction dir on a target in state st,
+// given the alert's status changes within the flap window. It returns when
+// the action may run and why (zero: now), and the end of a flap suppression
+// that starts with this action (zero: none).
+func (t Throttle) decide(st scalingState, dir string, flaps int, now time.Time) (until time.Time, reason string, suppress time.Time) {
+	if t.FlapCount > 0 && flaps >= t.FlapCount && !(st.suppressedUntil.Valid && st.suppressedUntil.Time.After(now)) {
+		suppress = now.Add(t.suppressFor())
+		st.suppressedUntil = sql.NullTime{Time: suppress, Valid: true}
+	}
+	later := func(at time.Time, why string) {
+		if at.After(now) && at.After(until) {
+			until, reason = at, why
+		}
+	}
+	if st.lastActionAt.Valid && t.Cooldown > 0 {
+		later(st.lastActionAt.Time.Add(t.Cooldown), "cooldown")
+	}
+	if dir == "down" && st.lastScaleUpAt.Valid && t.MinDwell > 0 {
+		later(st.lastScaleUpAt.Time.Add(t.MinDwell), "min_dwell")
+	}
+	if st.suppressedUntil.Valid {
+		later(st.suppressedUntil.Time, "flapping")
+	}
+	return until, reason, suppress
+}
+
+// throttleResult tells processAlert what to do with one action.
+type throttleResult struct {
+	target   string
+	deferred bool
+	until    time.Time
+	reason   string
+	flapping bool // suppression started with this alert
+	flaps    int
+}
+
+// throttle decides whether req may be emitted now. Emitted actions update the
+// target state and replace a deferred action for the target; actions that may
+// not run yet replace it instead.
+func throttle(tx *sql.Tx, rule Rule, req events.ActionRequested, status string, now time.Time) (throttleResult, error) {
+	res := throttleResult{target: targetKey(req)}
+	st, err := lockState(tx, res.target, now)
+	if err != nil {
+		return res, err
+	}
+	dir := scaleDirection(req, st, status)
+	if t := rule.Throttle; t != nil {
+		if t.FlapCount > 0 {
+			if err := tx.QueryRow(`SELECT COUNT(*) FROM alert_transitions WHERE alert_fp=$1 AND created_at > $2`,
+				req.AlertFP, now.Add(-t.FlapWindow)).Scan(&res.flaps); err != nil {
+				return res, err
+			}
+		}
+		var suppress time.Time
+		res.until, res.reason, suppress = t.decide(st, dir, res.flaps, now)
+		if !suppress.IsZero() {
+			if _, err := tx.Exec(`UPDATE scaling_state SET suppressed_until=$1, updated_at=$2 WHERE target=$3`, suppress, now, res.target); err != nil {
+				return res, err
+			}
+			res.flapping = true
+		}
+	}
+	if !res.until.IsZero() {
+		res.deferred = true
+		payload, _ := json.Marshal(req)
+		_, err := tx.Exec(`INSERT INTO deferred_actions (target, action_id, rule, alert_fp, payload, reason, due_at, created_at)
+			VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+			ON CONFLICT (target) DO UPDATE SET action_id=EXCLUDED.action_id, rule=EXCLUDED.rule, alert_fp=EXCLUDED.alert_fp,
+				payload=EXCLUDED.payload, reason=EXCLUDED.reason, due_at=EXCLUDED.due_at, created_at=EXCLUDED.created_at`,
+			res.target, req.ActionID, req.Rule, req.AlertFP, string(payload), res.reason, res.until, now)
+		return res, err
+	}
+	if _, err := tx.Exec(`DELETE FROM deferred_actions WHERE target=$1`, res.target); err != nil {
+		return res, err
+	}
+	return res, markEmitted(tx, res.target, req, dir, now)
+}
+
+// markEmitted records that req was emitted for target.
+func markEmitted(tx *sql.Tx, target string, req events.ActionRequested, dir string, now time.Time) error {
+	var desired any
+	if req.Kind == events.KindScaleDocker {
+		desired = req.DesiredReplicas
+	}
+	_, err := tx.Exec(`UPDATE scaling_state SET last_action_id=$1, last_action_at=$2, last_desired=COALESCE($3, last_desired),
+		last_scale_up_at=CASE WHEN $4 THEN $2 ELSE last_scale_up_at END, updated_at=$2 WHERE target=$5`,
+		req.ActionID, now, desired, dir == "up", target)
+	return err
+}
+
+// releaseDeferred emits deferred actions once they are due. Rows are locked
+// with SKIP LOCKED, so several rule engines can run it side by side.
+func (re *RuleEngine) releaseDeferred(interval time.Duration) {
+	for {
+		n, err := re.releaseDue(context.Background())
+		if err != nil {
+			log.Printf("release deferred actions: %v", err)
+		} else if n > 0 {
+			log.Printf("released %d deferred action(s)", n)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (re *RuleEngine) releaseDue(ctx context.Context) (int, error) {
+	tx, err := re.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	rows, err := tx.Query(`SELECT target, payload, reason FROM deferred_actions WHERE due_at <= now()
+		ORDER BY due_at LIMIT 50 FOR UPDATE SKIP LOCKED`)
+	if err != nil {
+		return 0, err
+	}
+	type due struct{ target, payload, reason string }
+	var list []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.target, &d.payload, &d.reason); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		list = append(list, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(list) == 0 {
+		return 0, nil
+	}
+	now := time.Now()
+	nowText := now.UTC().Format(time.RFC3339)
+	released := 0
+	for _, d := range list {
+		var req events.ActionRequested
+		if err := json.Unmarshal([]byte(d.payload), &req); err != nil {
+			// A bad row must not hold back the rest of the batch
+			log.Printf("deferred action for %s: drop undecodable payload: %v", d.target, err)
+			if _, err := tx.Exec(`DELETE FROM deferred_actions WHERE target=$1`, d.target); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		// Requested now, so that it orders after anything emitted meanwhile
+		req.CreatedAt = nowText
+		st, err := lockState(tx, d.target, now)
+		if err != nil {
+			return 0, err
+		}
+		if err := markEmitted(tx, d.target, req, scaleDirection(req, st, ""), now); err != nil {
+			return 0, err
+		}
+		pjson, _ := json.Marshal(req)
+		if err := writeOutbox(tx, req.Type, string(pjson), nowText); err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(`DELETE FROM deferred_actions WHERE target=$1`, d.target); err != nil {
+			return 0, err
+		}
+		if err := logDecision(tx, decision{
+			Kind: decisionThrottle, AlertFP: req.AlertFP, Rule: req.Rule, CorrelationID: req.CorrelationID,
+			Outcome: outcomeReleased, Reason: "deferred by " + d.reason, Events: []string{req.ActionID},
+			Details: map[string]any{"target": d.target, "desired_replicas": req.DesiredReplicas},
+		}, nowText); err != nil {
+			return 0, err
+		}
+		released++
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	re.relay.Notify()
+	return released, nil
+}
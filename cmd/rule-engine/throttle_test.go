@@ -0,0 +1,163 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ilya2309548/EventPulse/internal/events"
+)
+
+func TestThrottleDecide(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) sql.NullTime { return sql.NullTime{Time: now.Add(d), Valid: true} }
+	throttle := Throttle{Cooldown: 2 * time.Minute, MinDwell: 5 * time.Minute, FlapCount: 4, FlapWindow: 30 * time.Minute, FlapSuppress: 15 * time.Minute}
+	tests := []struct {
+		name         string
+		t            Throttle
+		st           scalingState
+		dir          string
+		flaps        int
+		wantUntil    time.Duration // from now; 0 means the action runs now
+		wantReason   string
+		wantSuppress time.Duration // from now; 0 means no new suppression
+	}{
+		{name: "no history", t: throttle, dir: "up"},
+		{name: "cooldown running", t: throttle, st: scalingState{lastActionAt: at(-time.Minute)}, dir: "up", wantUntil: time.Minute, wantReason: "cooldown"},
+		{name: "cooldown over", t: throttle, st: scalingState{lastActionAt: at(-3 * time.Minute)}, dir: "up"},
+		{name: "no cooldown configured", t: Throttle{}, st: scalingState{lastActionAt: at(-time.Second)}, dir: "up"},
+		{
+			name: "min_dwell holds a scale-down", t: throttle,
+			st:  scalingState{lastActionAt: at(-3 * time.Minute), lastScaleUpAt: at(-3 * time.Minute)},
+			dir: "down", wantUntil: 2 * time.Minute, wantReason: "min_dwell",
+		},
+		{
+			name: "min_dwell does not hold a scale-up", t: throttle,
+			st:  scalingState{lastActionAt: at(-3 * time.Minute), lastScaleUpAt: at(-3 * time.Minute)},
+			dir: "up",
+		},
+		{
+			name: "the later limit wins", t: Throttle{Cooldown: 10 * time.Minute, MinDwell: 5 * time.Minute},
+			st:  scalingState{lastActionAt: at(-time.Minute), lastScaleUpAt: at(-time.Minute)},
+			dir: "down", wantUntil: 9 * time.Minute, wantReason: "cooldown",
+		},
+		{name: "below flap count", t: throttle, dir: "up", flaps: 3},
+		{name: "flapping starts suppression", t: throttle, dir: "up", flaps: 4, wantUntil: 15 * time.Minute, wantReason: "flapping", wantSuppress: 15 * time.Minute},
+		{
+			name: "suppression defaults to the window", t: Throttle{FlapCount: 2, FlapWindow: 30 * time.Minute},
+			dir: "up", flaps: 2, wantUntil: 30 * time.Minute, wantReason: "flapping", wantSuppress: 30 * time.Minute,
+		},
+		{
+			name: "running suppression is not extended", t: throttle,
+			st:  scalingState{suppressedUntil: at(5 * time.Minute)},
+			dir: "up", flaps: 6, wantUntil: 5 * time.Minute, wantReason: "flapping",
+		},
+		{
+			name: "expired suppression restarts while flapping", t: throttle,
+			st:  scalingState{suppressedUntil: at(-time.Minute)},
+			dir: "up", flaps: 4, wantUntil: 15 * time.Minute, wantReason: "flapping", wantSuppress: 15 * time.Minute,
+		},
+		{name: "expired suppression without flaps", t: throttle, st: scalingState{suppressedUntil: at(-time.Minute)}, dir: "up", flaps: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			until, reason, suppress := tt.t.decide(tt.st, tt.dir, tt.flaps, now)
+			var wantUntil, wantSuppress time.Time
+			if tt.wantUntil != 0 {
+				wantUntil = now.Add(tt.wantUntil)
+			}
+			if tt.wantSuppress != 0 {
+				wantSuppress = now.Add(tt.wantSuppress)
+			}
+			if !until.Equal(wantUntil) || reason != tt.wantReason {
+				t.Errorf("until = %v (%q), want %v (%q)", until, reason, wantUntil, tt.wantReason)
+			}
+			if !suppress.Equal(wantSuppress) {
+				t.Errorf("suppress = %v, want %v", suppress, wantSuppress)
+			}
+		})
+	}
+}
+
+func TestScaleDirection(t *testing.T) {
+	scale := func(n int) events.ActionRequested {
+		req := events.NewActionRequested("fp", events.KindScaleDocker)
+		req.DesiredReplicas = n
+		return req
+	}
+	last := func(n int64) scalingState { return scalingState{lastDesired: sql.NullInt64{Int64: n, Valid: true}} }
+	tests := []struct {
+		name   string
+		req    events.ActionRequested
+		st     scalingState
+		status string
+		want   string
+	}{
+		{"more replicas", scale(3), last(2), events.StatusResolved, "up"},
+		{"fewer replicas", scale(1), last(2), events.StatusFiring, "down"},
+		{"same replicas", scale(2), last(2), events.StatusFiring, ""},
+		{"no history, firing", scale(2), scalingState{}, events.StatusFiring, "up"},
+		{"no history, resolved", scale(1), scalingState{}, events.StatusResolved, "down"},
+		{"not a scale action", events.NewActionRequested("fp", events.KindRestartRunner), last(2), events.StatusFiring, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scaleDirection(tt.req, tt.st, tt.status); got != tt.want {
+				t.Errorf("scaleDirection = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestThrottleValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		t    Throttle
+		ok   bool
+	}{
+		{"empty", Throttle{}, true},
+		{"full", Throttle{Cooldown: time.Minute, MinDwell: time.Minute, FlapCount: 2, FlapWindow: time.Minute}, true},
+		{"negative duration", Throttle{Cooldown: -time.Second}, false},
+		{"flap count of one", Throttle{FlapCount: 1, FlapWindow: time.Minute}, false},
+		{"flap count without window", Throttle{FlapCount: 3}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.t.validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: validate = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestThrottleJSON(t *testing.T) {
+	var th Throttle
+	if err := json.Unmarshal([]byte(`{"cooldown":"2m","min_dwell":"5m","flap_count":6,"flap_window":"30m"}`), &th); err != nil {
+		t.Fatal(err)
+	}
+	want := Throttle{Cooldown: 2 * time.Minute, MinDwell: 5 * time.Minute, FlapCount: 6, FlapWindow: 30 * time.Minute}
+	if th != want {
+		t.Fatalf("throttle = %+v, want %+v", th, want)
+	}
+	b, _ := json.Marshal(th)
+	if string(b) != `{"cooldown":"2m0s","min_dwell":"5m0s","flap_count":6,"flap_window":"30m0s"}` {
+		t.Errorf("marshal = %s", b)
+	}
+	if err := json.Unmarshal([]byte(`{"cooldown":"soon"}`), &th); err == nil {
+		t.Error("invalid duration accepted")
+	}
+}
+
+func TestMaxFlapWindow(t *testing.T) {
+	rules := []Rule{
+		{Name: "no throttle"},
+		{Name: "cooldown only", Throttle: &Throttle{Cooldown: time.Hour}},
+		{Name: "flaps", Throttle: &Throttle{FlapCount: 3, FlapWindow: 10 * time.Minute}},
+		{Name: "longer flaps", Throttle: &Throttle{FlapCount: 6, FlapWindow: 30 * time.Minute}},
+	}
+	if got := maxFlapWindow(rules); got != 30*time.Minute {
+		t.Errorf("maxFlapWindow = %s, want 30m", got)
+	}
+	if got := maxFlapWindow(rules[:2]); got != 0 {
+		t.Errorf("maxFlapWindow without flap detection = %s, want 0", got)
+	}
+}
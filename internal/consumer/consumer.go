@@ -0,0 +1,155 @@
+// Package consumer runs Kafka consumer loops with at-least-once semantics:
+// offsets are committed only after a message has been handled.
+package consumer
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// Handler processes one message. Returning nil marks it as done.
+type Handler func(ctx context.Context, msg kafka.Message) error
+
+// FailureFunc is called for a message that is given up on, either because the
+// handler returned a permanent error or because retries were exhausted.
+type FailureFunc func(ctx context.Context, msg kafka.Message, err error, attempts int) error
+
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not retryable (malformed payload, unknown kind, ...).
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent.
+func IsPermanent(err error) bool {
+	var pe *permanentError
+	return errors.As(err, &pe)
+}
+
+// Consumer fetches messages from Reader and passes them to Handler.
+//
+// Failure policy: transient errors are retried up to MaxAttempts times with
+// exponential backoff, blocking the partition meanwhile. Permanent errors and
+// messages that exhausted their retries are passed to OnFailure (logged when
+// nil) and then committed so the partition can make progress.
+type Consumer struct {
+	Name        string
+	Reader      *kafka.Reader
+	Handler     Handler
+	OnFailure   FailureFunc
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+// LoadEnv overrides retry settings from CONSUMER_MAX_ATTEMPTS,
+// CONSUMER_BACKOFF and CONSUMER_MAX_BACKOFF when set.
+func (c *Consumer) LoadEnv() {
+	if v := strings.TrimSpace(os.Getenv("CONSUMER_MAX_ATTEMPTS")); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			c.MaxAttempts = n
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("CONSUMER_BACKOFF")); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			c.Backoff = d
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("CONSUMER_MAX_BACKOFF")); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			c.MaxBackoff = d
+		}
+	}
+}
+
+func (c *Consumer) defaults() {
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 5
+	}
+	if c.Backoff <= 0 {
+		c.Backoff = 500 * time.Millisecond
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = 30 * time.Second
+	}
+}
+
+// Run consumes until ctx is cancelled.
+func (c *Consumer) Run(ctx context.Context) {
+	c.defaults()
+	for {
+		msg, err := c.Reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("%s: fetch error: %v", c.Name, err)
+			time.Sleep(1 * time.Second)
+			continue
+		}
+		c.handle(ctx, msg)
+		if ctx.Err() != nil {
+			return
+		}
+		c.commit(ctx, msg)
+	}
+}
+
+func (c *Consumer) handle(ctx context.Context, msg kafka.Message) {
+	delay := c.Backoff
+	for attempt := 1; ; attempt++ {
+		err := c.Handler(ctx, msg)
+		if err == nil {
+			return
+		}
+		if IsPermanent(err) || attempt >= c.MaxAttempts {
+			log.Printf("%s: giving up on %s[%d]@%d after %d attempt(s): %v", c.Name, msg.Topic, msg.Partition, msg.Offset, attempt, err)
+			if c.OnFailure != nil {
+				if ferr := c.OnFailure(ctx, msg, err, attempt); ferr != nil {
+					log.Printf("%s: failure handler error for %s[%d]@%d: %v", c.Name, msg.Topic, msg.Partition, msg.Offset, ferr)
+				}
+			}
+			return
+		}
+		log.Printf("%s: attempt %d for %s[%d]@%d failed, retry in %s: %v", c.Name, attempt, msg.Topic, msg.Partition, msg.Offset, delay, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > c.MaxBackoff {
+			delay = c.MaxBackoff
+		}
+	}
+}
+
+// commit retries a few times; a lost commit only causes a redelivery, which
+// inbox deduplication absorbs.
+func (c *Consumer) commit(ctx context.Context, msg kafka.Message) {
+	for attempt := 1; ; attempt++ {
+		err := c.Reader.CommitMessages(ctx, msg)
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+		log.Printf("%s: commit %s[%d]@%d failed (attempt %d): %v", c.Name, msg.Topic, msg.Partition, msg.Offset, attempt, err)
+		if attempt >= 5 {
+			return
+		}
+		time.Sleep(1 * time.Second)
+	}
+}
@@ -0,0 +1,154 @@
+// Package containers is the action runner's view of the container engine:
+// list/inspect/create/start/remove with typed errors. Engine implements it
+// with the Docker SDK.
+package containers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Container is a container as seen by the runner.
+type Container struct {
+	ID      string
+	Name    string
+	Image   string
+	Labels  map[string]string
+	Running bool
+	// Health is the Docker healthcheck status (starting, healthy, unhealthy),
+	// empty when the container has no healthcheck. Only set by Inspect.
+	Health string
+	// IPAddress is the container's address on its first network. Only set by Inspect.
+	IPAddress string
+	Created   time.Time
+	// Spec is the configuration the container was created with, usable as a
+	// template for identical containers. Only set by Inspect.
+	Spec Spec
+}
+
+// Spec describes a container to create. It is also the format of replica
+// template files, hence the JSON tags.
+type Spec struct {
+	Name    string            `json:"name,omitempty"`
+	Image   string            `json:"image"`
+	Cmd     []string          `json:"cmd,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Env     []string          `json:"env,omitempty"`
+	Network string            `json:"network,omitempty"`
+	// RestartPolicy is a Docker restart policy name ("always", "unless-stopped", ...).
+	RestartPolicy string       `json:"restart_policy,omitempty"`
+	Healthcheck   *Healthcheck `json:"healthcheck,omitempty"`
+	// NanoCPUs limits CPU in units of 1e-9 CPUs; 0 means unlimited.
+	NanoCPUs int64 `json:"nano_cpus,omitempty"`
+	// Memory limits memory in bytes; 0 means unlimited.
+	Memory int64 `json:"memory,omitempty"`
+}
+
+// Healthcheck mirrors the Docker healthcheck configuration. In JSON the
+// durations are strings such as "10s".
+type Healthcheck struct {
+	Test        []string      `json:"test"`
+	Interval    time.Duration `json:"interval,omitempty"`
+	Timeout     time.Duration `json:"timeout,omitempty"`
+	StartPeriod time.Duration `json:"start_period,omitempty"`
+	Retries     int           `json:"retries,omitempty"`
+}
+
+// Client is the subset of the container engine the runner uses.
+type Client interface {
+	// List returns containers carrying all the given labels; all includes
+	// stopped containers.
+	List(ctx context.Context, labels map[string]string, all bool) ([]Container, error)
+	Inspect(ctx context.Context, id string) (Container, error)
+	// Create creates a container and returns its id; it is not started.
+	Create(ctx context.Context, spec Spec) (string, error)
+	Start(ctx context.Context, id string) error
+	// Stop sends SIGTERM and kills the container if it has not exited after grace.
+	Stop(ctx context.Context, id string, grace time.Duration) error
+	// Remove stops (when force is set) and removes a container.
+	Remove(ctx context.Context, id string, force bool) error
+	// Logs returns the last tail lines of the container's stdout and stderr.
+	Logs(ctx context.Context, id string, tail int) (string, error)
+}
+
+var (
+	// ErrNotFound is returned when a container or image does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when the engine rejects an operation because of
+	// the container's state or a name clash.
+	ErrConflict = errors.New("conflict")
+	// ErrUnavailable is returned when the engine cannot be reached or fails
+	// with a server error; such errors are usually transient.
+	ErrUnavailable = errors.New("engine unavailable")
+)
+
+// Error is returned by Client operations.
+type Error struct {
+	Op  string // list, inspect, create, start, stop, remove, logs
+	ID  string // container id or name, if any
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.ID == "" {
+		return fmt.Sprintf("container %s: %v", e.Op, e.Err)
+	}
+	return fmt.Sprintf("container %s %s: %v", e.Op, e.ID, e.Err)
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// IsNotFound reports whether err means the container does not exist.
+func IsNotFound(err error) bool { return errors.Is(err, ErrNotFound) }
+
+// IsConflict reports whether err is a state or name conflict.
+func IsConflict(err error) bool { return errors.Is(err, ErrConflict) }
+
+// IsUnavailable reports whether err is a connection or server-side engine error.
+func IsUnavailable(err error) bool { return errors.Is(err, ErrUnavailable) }
+
+type healthcheckJSON struct {
+	Test        []string `json:"test"`
+	Interval    string   `json:"interval,omitempty"`
+	Timeout     string   `json:"timeout,omitempty"`
+	StartPeriod string   `json:"start_period,omitempty"`
+	Retries     int      `json:"retries,omitempty"`
+}
+
+// MarshalJSON writes durations as strings ("10s").
+func (h Healthcheck) MarshalJSON() ([]byte, error) {
+	str := func(d time.Duration) string {
+		if d == 0 {
+			return ""
+		}
+		return d.String()
+	}
+	return json.Marshal(healthcheckJSON{Test: h.Test, Interval: str(h.Interval), Timeout: str(h.Timeout), StartPeriod: str(h.StartPeriod), Retries: h.Retries})
+}
+
+// UnmarshalJSON reads durations written as strings ("10s").
+func (h *Healthcheck) UnmarshalJSON(b []byte) error {
+	var v healthcheckJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*h = Healthcheck{Test: v.Test, Retries: v.Retries}
+	for _, d := range []struct {
+		name string
+		src  string
+		dst  *time.Duration
+	}{{"interval", v.Interval, &h.Interval}, {"timeout", v.Timeout, &h.Timeout}, {"start_period", v.StartPeriod, &h.StartPeriod}} {
+		if d.src == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.src)
+		if err != nil {
+			return fmt.Errorf("healthcheck.%s: %w", d.name, err)
+		}
+		*d.dst = parsed
+	}
+	return nil
+}
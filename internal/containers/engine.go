@@ -0,0 +1,196 @@
+package containers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// Engine is a Client backed by the Docker SDK.
+type Engine struct {
+	api *client.Client
+}
+
+// NewEngine connects to the daemon configured by the standard Docker
+// environment: DOCKER_HOST (the local unix socket when unset),
+// DOCKER_API_VERSION (negotiated with the daemon when unset), DOCKER_CERT_PATH
+// and DOCKER_TLS_VERIFY.
+func NewEngine() (*Engine, error) {
+	api, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("docker client: %w", err)
+	}
+	return &Engine{api: api}, nil
+}
+
+// wrap turns an SDK error into *Error, mapping not found and conflict errors
+// to ErrNotFound/ErrConflict and connection or server-side failures to
+// ErrUnavailable.
+func wrap(ctx context.Context, op, id string, err error) error {
+	if err == nil {
+		return nil
+	}
+	switch {
+	case ctx.Err() != nil:
+	case errdefs.IsNotFound(err):
+		err = fmt.Errorf("%w: %v", ErrNotFound, err)
+	case errdefs.IsConflict(err):
+		err = fmt.Errorf("%w: %v", ErrConflict, err)
+	case client.IsErrConnectionFailed(err), errdefs.IsSystem(err), errdefs.IsUnavailable(err), errdefs.IsUnknown(err):
+		err = fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return &Error{Op: op, ID: id, Err: err}
+}
+
+func (e *Engine) List(ctx context.Context, labels map[string]string, all bool) ([]Container, error) {
+	args := filters.NewArgs()
+	for k, v := range labels {
+		args.Add("label", k+"="+v)
+	}
+	list, err := e.api.ContainerList(ctx, types.ContainerListOptions{All: all, Filters: args})
+	if err != nil {
+		return nil, wrap(ctx, "list", "", err)
+	}
+	out := make([]Container, 0, len(list))
+	for _, c := range list {
+		var name string
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		out = append(out, Container{
+			ID:      c.ID,
+			Name:    name,
+			Image:   c.Image,
+			Labels:  c.Labels,
+			Running: c.State == "running",
+			Created: time.Unix(c.Created, 0).UTC(),
+		})
+	}
+	return out, nil
+}
+
+func (e *Engine) Inspect(ctx context.Context, id string) (Container, error) {
+	c, err := e.api.ContainerInspect(ctx, id)
+	if err != nil {
+		return Container{}, wrap(ctx, "inspect", id, err)
+	}
+	out := Container{
+		ID:   c.ID,
+		Name: strings.TrimPrefix(c.Name, "/"),
+	}
+	if c.State != nil {
+		out.Running = c.State.Running
+		if c.State.Health != nil {
+			out.Health = c.State.Health.Status
+		}
+	}
+	if ns := c.NetworkSettings; ns != nil {
+		out.IPAddress = ns.IPAddress
+		for _, n := range ns.Networks {
+			if n != nil && n.IPAddress != "" {
+				out.IPAddress = n.IPAddress
+				break
+			}
+		}
+	}
+	if t, err := time.Parse(time.RFC3339Nano, c.Created); err == nil {
+		out.Created = t.UTC()
+	}
+	if cfg := c.Config; cfg != nil {
+		out.Image = cfg.Image
+		out.Labels = cfg.Labels
+		out.Spec = Spec{Image: cfg.Image, Cmd: cfg.Cmd, Labels: cfg.Labels, Env: cfg.Env}
+		if hc := cfg.Healthcheck; hc != nil && len(hc.Test) > 0 {
+			out.Spec.Healthcheck = &Healthcheck{
+				Test:        hc.Test,
+				Interval:    hc.Interval,
+				Timeout:     hc.Timeout,
+				StartPeriod: hc.StartPeriod,
+				Retries:     hc.Retries,
+			}
+		}
+	}
+	if hc := c.HostConfig; hc != nil {
+		out.Spec.Network = string(hc.NetworkMode)
+		out.Spec.RestartPolicy = hc.RestartPolicy.Name
+		out.Spec.NanoCPUs = hc.NanoCPUs
+		out.Spec.Memory = hc.Memory
+	}
+	return out, nil
+}
+
+func (e *Engine) Create(ctx context.Context, spec Spec) (string, error) {
+	cfg := &container.Config{
+		Image:  spec.Image,
+		Cmd:    spec.Cmd,
+		Labels: spec.Labels,
+		Env:    spec.Env,
+	}
+	if hc := spec.Healthcheck; hc != nil {
+		cfg.Healthcheck = &container.HealthConfig{
+			Test:        hc.Test,
+			Interval:    hc.Interval,
+			Timeout:     hc.Timeout,
+			StartPeriod: hc.StartPeriod,
+			Retries:     hc.Retries,
+		}
+	}
+	host := &container.HostConfig{
+		NetworkMode:   container.NetworkMode(spec.Network),
+		RestartPolicy: container.RestartPolicy{Name: spec.RestartPolicy},
+		Resources:     container.Resources{NanoCPUs: spec.NanoCPUs, Memory: spec.Memory},
+	}
+	resp, err := e.api.ContainerCreate(ctx, cfg, host, nil, nil, spec.Name)
+	if err != nil {
+		return "", wrap(ctx, "create", spec.Name, err)
+	}
+	return resp.ID, nil
+}
+
+func (e *Engine) Start(ctx context.Context, id string) error {
+	return wrap(ctx, "start", id, e.api.ContainerStart(ctx, id, types.ContainerStartOptions{}))
+}
+
+func (e *Engine) Stop(ctx context.Context, id string, grace time.Duration) error {
+	timeout := int(grace.Seconds())
+	return wrap(ctx, "stop", id, e.api.ContainerStop(ctx, id, container.StopOptions{Timeout: &timeout}))
+}
+
+func (e *Engine) Remove(ctx context.Context, id string, force bool) error {
+	return wrap(ctx, "remove", id, e.api.ContainerRemove(ctx, id, types.ContainerRemoveOptions{Force: force}))
+}
+
+// Logs demultiplexes the stdout/stderr frames of containers without a TTY;
+// TTY output is returned as is.
+func (e *Engine) Logs(ctx context.Context, id string, tail int) (string, error) {
+	c, err := e.api.ContainerInspect(ctx, id)
+	if err != nil {
+		return "", wrap(ctx, "logs", id, err)
+	}
+	rc, err := e.api.ContainerLogs(ctx, id, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Tail: strconv.Itoa(tail)})
+	if err != nil {
+		return "", wrap(ctx, "logs", id, err)
+	}
+	defer rc.Close()
+	var out bytes.Buffer
+	if c.Config != nil && c.Config.Tty {
+		_, err = io.Copy(&out, rc)
+	} else {
+		_, err = stdcopy.StdCopy(&out, &out, rc)
+	}
+	if err != nil {
+		return "", wrap(ctx, "logs", id, err)
+	}
+	return out.String(), nil
+}
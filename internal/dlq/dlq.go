@@ -0,0 +1,337 @@
+// Package dlq stores messages a consumer gave up on, publishes them to a
+// per-topic dead-letter topic and lets operators list and replay them.
+package dlq
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// Suffix is appended to a source topic to form its dead-letter topic.
+const Suffix = ".dlq"
+
+// Entry is a dead-lettered message together with the reason it failed.
+type Entry struct {
+	ID          int64           `json:"id"`
+	Consumer    string          `json:"consumer"`
+	SourceTopic string          `json:"source_topic"`
+	Partition   int             `json:"partition"`
+	Offset      int64           `json:"offset"`
+	Key         string          `json:"key,omitempty"`
+	Payload     json.RawMessage `json:"payload"`
+	Error       string          `json:"error"`
+	Attempts    int             `json:"attempts"`
+	CreatedAt   string          `json:"created_at"`
+	ReplayCount int             `json:"replay_count"`
+	ReplayedAt  string          `json:"replayed_at,omitempty"`
+}
+
+// Migrate creates the dlq_entries table.
+func Migrate(db *sql.DB) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS dlq_entries (
+			id SERIAL PRIMARY KEY,
+			consumer TEXT NOT NULL,
+			source_topic TEXT NOT NULL,
+			partition INTEGER NOT NULL,
+			message_offset BIGINT NOT NULL,
+			message_key TEXT,
+			payload TEXT NOT NULL,
+			error TEXT NOT NULL,
+			attempts INTEGER NOT NULL,
+			created_at TEXT NOT NULL,
+			replay_count INTEGER NOT NULL DEFAULT 0,
+			replayed_at TEXT
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_dlq_topic ON dlq_entries(source_topic)`,
+	}
+	for _, s := range stmts {
+		if _, err := db.Exec(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Queue records failed messages of one consumer.
+type Queue struct {
+	db       *sql.DB
+	brokers  []string
+	consumer string
+
+	mu      sync.Mutex
+	writers map[string]*kafka.Writer
+}
+
+// New creates a queue for the named consumer. Dead-letter and replay
+// messages are written to brokers.
+func New(db *sql.DB, brokers []string, consumer string) *Queue {
+	return &Queue{db: db, brokers: brokers, consumer: consumer, writers: map[string]*kafka.Writer{}}
+}
+
+func (q *Queue) writer(topic string) *kafka.Writer {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	w, ok := q.writers[topic]
+	if !ok {
+		w = &kafka.Writer{Addr: kafka.TCP(q.brokers...), Topic: topic, Balancer: &kafka.LeastBytes{}, RequiredAcks: kafka.RequireAll}
+		q.writers[topic] = w
+	}
+	return w
+}
+
+// deadLetter is the message published to the dead-letter topic.
+type deadLetter struct {
+	ID          int64           `json:"dlq_id"`
+	Consumer    string          `json:"consumer"`
+	SourceTopic string          `json:"source_topic"`
+	Partition   int             `json:"partition"`
+	Offset      int64           `json:"offset"`
+	Payload     json.RawMessage `json:"payload"`
+	Error       string          `json:"error"`
+	Attempts    int             `json:"attempts"`
+	FailedAt    string          `json:"failed_at"`
+}
+
+// Add stores msg and publishes it to the dead-letter topic of its source
+// topic. It matches consumer.FailureFunc. The database row is written first,
+// so the entry stays replayable even if the broker is unavailable.
+func (q *Queue) Add(ctx context.Context, msg kafka.Message, cause error, attempts int) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	var id int64
+	err := q.db.QueryRowContext(ctx, `INSERT INTO dlq_entries (consumer, source_topic, partition, message_offset, message_key, payload, error, attempts, created_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9) RETURNING id`,
+		q.consumer, msg.Topic, msg.Partition, msg.Offset, string(msg.Key), string(msg.Value), cause.Error(), attempts, now).Scan(&id)
+	if err != nil {
+		return fmt.Errorf("store dlq entry: %w", err)
+	}
+	dl := deadLetter{
+		ID:          id,
+		Consumer:    q.consumer,
+		SourceTopic: msg.Topic,
+		Partition:   msg.Partition,
+		Offset:      msg.Offset,
+		Payload:     rawJSON(msg.Value),
+		Error:       cause.Error(),
+		Attempts:    attempts,
+		FailedAt:    now,
+	}
+	pjson, _ := json.Marshal(dl)
+	pctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := q.writer(msg.Topic+Suffix).WriteMessages(pctx, kafka.Message{Key: msg.Key, Value: pjson}); err != nil {
+		return fmt.Errorf("publish dlq entry %d: %w", id, err)
+	}
+	return nil
+}
+
+// rawJSON keeps valid JSON payloads as-is and quotes anything else, so a
+// payload that failed to decode is still carried verbatim.
+func rawJSON(b []byte) json.RawMessage {
+	if json.Valid(b) {
+		return json.RawMessage(b)
+	}
+	quoted, _ := json.Marshal(string(b))
+	return json.RawMessage(quoted)
+}
+
+var errNotFound = errors.New("dlq entry not found")
+
+const entryColumns = `id, consumer, source_topic, partition, message_offset, COALESCE(message_key,''), payload, error, attempts, created_at, replay_count, COALESCE(replayed_at,'')`
+
+func scanEntry(sc interface{ Scan(...any) error }) (Entry, error) {
+	var e Entry
+	var payload string
+	err := sc.Scan(&e.ID, &e.Consumer, &e.SourceTopic, &e.Partition, &e.Offset, &e.Key, &payload, &e.Error, &e.Attempts, &e.CreatedAt, &e.ReplayCount, &e.ReplayedAt)
+	e.Payload = rawJSON([]byte(payload))
+	return e, err
+}
+
+// List returns entries, newest first, optionally filtered by source topic and
+// by whether they were already replayed.
+func (q *Queue) List(ctx context.Context, topic string, pendingOnly bool, limit int) ([]Entry, error) {
+	where := []string{"consumer=$1"}
+	args := []any{q.consumer}
+	if topic != "" {
+		args = append(args, topic)
+		where = append(where, fmt.Sprintf("source_topic=$%d", len(args)))
+	}
+	if pendingOnly {
+		where = append(where, "replayed_at IS NULL")
+	}
+	args = append(args, limit)
+	rows, err := q.db.QueryContext(ctx, `SELECT `+entryColumns+` FROM dlq_entries WHERE `+strings.Join(where, " AND ")+
+		fmt.Sprintf(` ORDER BY id DESC LIMIT $%d`, len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Entry{}
+	for rows.Next() {
+		e, err := scanEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// Get returns a single entry.
+func (q *Queue) Get(ctx context.Context, id int64) (Entry, error) {
+	e, err := scanEntry(q.db.QueryRowContext(ctx, `SELECT `+entryColumns+` FROM dlq_entries WHERE id=$1 AND consumer=$2`, id, q.consumer))
+	if err == sql.ErrNoRows {
+		return e, errNotFound
+	}
+	return e, err
+}
+
+// Replay re-publishes the original payload of entry id to its source topic.
+func (q *Queue) Replay(ctx context.Context, id int64) (Entry, error) {
+	e, err := q.Get(ctx, id)
+	if err != nil {
+		return e, err
+	}
+	var payload []byte
+	if err := q.db.QueryRowContext(ctx, `SELECT payload FROM dlq_entries WHERE id=$1`, id).Scan(&payload); err != nil {
+		return e, err
+	}
+	pctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	msg := kafka.Message{Value: payload}
+	if e.Key != "" {
+		msg.Key = []byte(e.Key)
+	}
+	if err := q.writer(e.SourceTopic).WriteMessages(pctx, msg); err != nil {
+		return e, fmt.Errorf("replay dlq entry %d: %w", id, err)
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	if _, err := q.db.ExecContext(ctx, `UPDATE dlq_entries SET replay_count=replay_count+1, replayed_at=$1 WHERE id=$2`, now, id); err != nil {
+		return e, err
+	}
+	e.ReplayCount++
+	e.ReplayedAt = now
+	return e, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// Register mounts the admin endpoints on mux:
+//
+//	GET  /dlq?topic=&pending=true&limit=   list entries
+//	GET  /dlq/{id}                          show one entry
+//	POST /dlq/{id}/replay                   re-inject one entry
+//	POST /dlq/replay  {"ids":[1,2]}         re-inject selected entries
+func (q *Queue) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/dlq", q.handleList)
+	mux.HandleFunc("/dlq/", q.handleEntry)
+}
+
+func (q *Queue) handleList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			http.Error(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	pending := r.URL.Query().Get("pending") == "true"
+	entries, err := q.List(r.Context(), r.URL.Query().Get("topic"), pending, limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func (q *Queue) handleEntry(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/dlq/"), "/")
+	if rest == "replay" {
+		q.handleReplayMany(w, r)
+		return
+	}
+	parts := strings.Split(rest, "/")
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid dlq id", http.StatusBadRequest)
+		return
+	}
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		e, err := q.Get(r.Context(), id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, e)
+	case len(parts) == 2 && parts[1] == "replay" && r.Method == http.MethodPost:
+		e, err := q.Replay(r.Context(), id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, e)
+	case len(parts) <= 2:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (q *Queue) handleReplayMany(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		IDs []int64 `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.IDs) == 0 {
+		http.Error(w, `body must be {"ids":[...]}`, http.StatusBadRequest)
+		return
+	}
+	type result struct {
+		ID    int64  `json:"id"`
+		OK    bool   `json:"ok"`
+		Error string `json:"error,omitempty"`
+	}
+	out := make([]result, 0, len(req.IDs))
+	for _, id := range req.IDs {
+		res := result{ID: id, OK: true}
+		if _, err := q.Replay(r.Context(), id); err != nil {
+			res.OK, res.Error = false, err.Error()
+		}
+		out = append(out, res)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,580 @@
+// Package events defines the versioned event payloads exchanged between
+// EventPulse services over Kafka, with constructors, validation and decoders.
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SchemaVersion is the payload version written by this code. Payloads without
+// a version field are treated as version 1 (written before versioning).
+const SchemaVersion = 1
+
+// Event types.
+const (
+	TypeAlertRaised     = "alert.raised"
+	TypeIncidentOpened  = "incident.opened"
+	TypeIncidentNote    = "incident.note"
+	TypeActionRequested = "action.requested"
+	TypeActionCompleted = "action.completed"
+	TypeActionFailed    = "action.failed"
+	TypeActionRetrying  = "action.retrying"
+	TypeActionCancel    = "action.cancel"
+	TypeActionCancelled = "action.cancelled"
+)
+
+// Action kinds understood by the action runner.
+const (
+	KindScaleDocker   = "scale_docker"
+	KindRestartRunner = "restart_runner"
+)
+
+// Alert statuses as reported by Alertmanager.
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+// Envelope holds the fields common to every event; use Peek to route a raw
+// payload before decoding it into its concrete type.
+type Envelope struct {
+	Type    string `json:"type"`
+	Version int    `json:"version"`
+}
+
+// AlertRaised is published by ingest for every alert in an Alertmanager webhook.
+type AlertRaised struct {
+	Type        string            `json:"type"`
+	Version     int               `json:"version"`
+	Fingerprint string            `json:"fingerprint"`
+	Status      string            `json:"status"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	StartsAt    string            `json:"starts_at,omitempty"`
+	EndsAt      string            `json:"ends_at,omitempty"`
+	EpisodeID   string            `json:"episode_id,omitempty"`
+	DedupKey    string            `json:"dedup_key"`
+	CreatedAt   string            `json:"created_at"`
+}
+
+// IncidentOpened is published by the rule engine when a rule opens an incident.
+type IncidentOpened struct {
+	Type          string            `json:"type"`
+	Version       int               `json:"version"`
+	IncidentID    string            `json:"incident_id"`
+	AlertFP       string            `json:"alert_fp"`
+	Rule          string            `json:"rule,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	CorrelationID string            `json:"correlation_id,omitempty"`
+	CausationID   string            `json:"causation_id,omitempty"`
+	DedupKey      string            `json:"dedup_key"`
+	CreatedAt     string            `json:"created_at"`
+}
+
+// IncidentNote adds a note from an automated component to an incident's
+// timeline, e.g. that the rule engine suppressed actions for a flapping
+// alert. The incident is found by IncidentID, or else by AlertFP.
+type IncidentNote struct {
+	Type          string `json:"type"`
+	Version       int    `json:"version"`
+	IncidentID    string `json:"incident_id,omitempty"`
+	AlertFP       string `json:"alert_fp"`
+	Source        string `json:"source"`
+	Note          string `json:"note"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+	CausationID   string `json:"causation_id,omitempty"`
+	DedupKey      string `json:"dedup_key"`
+	CreatedAt     string `json:"created_at"`
+}
+
+// ActionRequested asks an action runner to execute an action of the given kind.
+// Parameters that have no dedicated field are carried in Params.
+//
+// IncidentID links the action to its incident. CorrelationID is shared by all
+// events of one alert episode (or outage); CausationID is the id of the event
+// that caused this one. TimeoutSeconds, when set, replaces the executor's
+// default timeout for each attempt.
+//
+// A compensating action (requested after another action failed) carries the
+// id of the first failed action in CompensationOf and its position in the
+// compensation chain in CompensationNo.
+type ActionRequested struct {
+	Type            string         `json:"type"`
+	Version         int            `json:"version"`
+	ActionID        string         `json:"action_id"`
+	Kind            string         `json:"kind"`
+	AlertFP         string         `json:"alert_fp,omitempty"`
+	IncidentID      string         `json:"incident_id,omitempty"`
+	CorrelationID   string         `json:"correlation_id,omitempty"`
+	CausationID     string         `json:"causation_id,omitempty"`
+	Rule            string         `json:"rule,omitempty"`
+	DesiredReplicas int            `json:"desired_replicas,omitempty"`
+	TargetRunner    string         `json:"target_runner,omitempty"`
+	Target          *Target        `json:"target,omitempty"`
+	Params          map[string]any `json:"params,omitempty"`
+	TimeoutSeconds  int            `json:"timeout_seconds,omitempty"`
+	CompensationOf  string         `json:"compensation_of,omitempty"`
+	CompensationNo  int            `json:"compensation_attempt,omitempty"`
+	DedupKey        string         `json:"dedup_key"`
+	CreatedAt       string         `json:"created_at"`
+}
+
+// ActionCancel asks the action runners to stop an action. It may arrive
+// before the action itself, in which case the action is never executed.
+type ActionCancel struct {
+	Type        string `json:"type"`
+	Version     int    `json:"version"`
+	ActionID    string `json:"action_id"`
+	Reason      string `json:"reason,omitempty"`
+	RequestedBy string `json:"requested_by,omitempty"`
+	DedupKey    string `json:"dedup_key"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// Target selects the containers a scale action applies to: those with label
+// service=Service, or those carrying all Labels. Replica counts are kept
+// within MinReplicas..MaxReplicas when set.
+type Target struct {
+	Service     string            `json:"service,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	MinReplicas int               `json:"min_replicas,omitempty"`
+	MaxReplicas int               `json:"max_replicas,omitempty"`
+}
+
+// Validate checks that t selects something and its bounds are consistent.
+func (t Target) Validate(typ string) error {
+	if t.Service == "" && len(t.Labels) == 0 {
+		return invalid(typ, "target", "needs service or labels")
+	}
+	if t.MinReplicas < 0 || t.MaxReplicas < 0 {
+		return invalid(typ, "target", "replica bounds must be >= 0")
+	}
+	if t.MaxReplicas > 0 && t.MinReplicas > t.MaxReplicas {
+		return invalid(typ, "target", fmt.Sprintf("min_replicas %d > max_replicas %d", t.MinReplicas, t.MaxReplicas))
+	}
+	return nil
+}
+
+// ActionResult is published by the action runner as action.completed,
+// action.failed or action.cancelled, and as action.retrying after a failed
+// attempt that will be retried; Error is set for failures and carries the
+// reason of a cancellation. Incident and correlation ids, the rule and the
+// compensation chain are echoed from the request and CausationID is the
+// request's action id.
+type ActionResult struct {
+	Type            string            `json:"type"`
+	Version         int               `json:"version"`
+	ActionID        string            `json:"action_id"`
+	Kind            string            `json:"kind"`
+	AlertFP         string            `json:"alert_fp,omitempty"`
+	IncidentID      string            `json:"incident_id,omitempty"`
+	CorrelationID   string            `json:"correlation_id,omitempty"`
+	CausationID     string            `json:"causation_id,omitempty"`
+	Rule            string            `json:"rule,omitempty"`
+	CompensationOf  string            `json:"compensation_of,omitempty"`
+	CompensationNo  int               `json:"compensation_attempt,omitempty"`
+	DesiredReplicas int               `json:"desired_replicas,omitempty"`
+	TargetRunner    string            `json:"target_runner,omitempty"`
+	Target          *Target           `json:"target,omitempty"`
+	Outputs         map[string]string `json:"outputs,omitempty"`
+	Error           string            `json:"error,omitempty"`
+	Attempt         int               `json:"attempt,omitempty"`
+	MaxAttempts     int               `json:"max_attempts,omitempty"`
+	RetryAt         string            `json:"retry_at,omitempty"`
+	DedupKey        string            `json:"dedup_key"`
+	CreatedAt       string            `json:"created_at"`
+}
+
+func now() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// NewAlertRaised builds an alert.raised event. The dedup key is unique per
+// fingerprint, episode and status.
+func NewAlertRaised(fingerprint, status, episodeID string) AlertRaised {
+	return AlertRaised{
+		Type:        TypeAlertRaised,
+		Version:     SchemaVersion,
+		Fingerprint: fingerprint,
+		Status:      status,
+		EpisodeID:   episodeID,
+		DedupKey:    fmt.Sprintf("%s:%s:%s:%s", fingerprint, TypeAlertRaised, episodeID, status),
+		CreatedAt:   now(),
+	}
+}
+
+// NewIncidentOpened builds an incident.opened event with a fresh incident id.
+func NewIncidentOpened(alertFP string) IncidentOpened {
+	id := fmt.Sprintf("inc-%d", time.Now().UnixNano())
+	return IncidentOpened{
+		Type:       TypeIncidentOpened,
+		Version:    SchemaVersion,
+		IncidentID: id,
+		AlertFP:    alertFP,
+		DedupKey:   id,
+		CreatedAt:  now(),
+	}
+}
+
+// NewIncidentNote builds an incident.note event.
+func NewIncidentNote(incidentID, alertFP, source, note string) IncidentNote {
+	return IncidentNote{
+		Type:       TypeIncidentNote,
+		Version:    SchemaVersion,
+		IncidentID: incidentID,
+		AlertFP:    alertFP,
+		Source:     source,
+		Note:       note,
+		DedupKey:   fmt.Sprintf("note-%d", time.Now().UnixNano()),
+		CreatedAt:  now(),
+	}
+}
+
+// NewActionRequested builds an action.requested event with a fresh action id.
+func NewActionRequested(alertFP, kind string) ActionRequested {
+	id := fmt.Sprintf("act-%d", time.Now().UnixNano())
+	return ActionRequested{
+		Type:      TypeActionRequested,
+		Version:   SchemaVersion,
+		ActionID:  id,
+		Kind:      kind,
+		AlertFP:   alertFP,
+		DedupKey:  id,
+		CreatedAt: now(),
+	}
+}
+
+// NewActionCompleted builds the action.completed result for req.
+func NewActionCompleted(req ActionRequested) ActionResult {
+	return newActionResult(req, TypeActionCompleted, "")
+}
+
+// NewActionFailed builds the action.failed result for req.
+func NewActionFailed(req ActionRequested, err error) ActionResult {
+	msg := "unknown error"
+	if err != nil {
+		msg = err.Error()
+	}
+	return newActionResult(req, TypeActionFailed, msg)
+}
+
+// NewActionRetrying reports that attempt of max failed with err and the
+// action is retried at retryAt.
+func NewActionRetrying(req ActionRequested, attempt, max int, err error, retryAt time.Time) ActionResult {
+	msg := "unknown error"
+	if err != nil {
+		msg = err.Error()
+	}
+	res := newActionResult(req, TypeActionRetrying, msg)
+	res.Attempt, res.MaxAttempts = attempt, max
+	res.RetryAt = retryAt.UTC().Format(time.RFC3339)
+	res.DedupKey = fmt.Sprintf("%s:retrying:%d", req.ActionID, attempt)
+	return res
+}
+
+// NewActionCancelled builds the action.cancelled result for req.
+func NewActionCancelled(req ActionRequested, reason string) ActionResult {
+	if reason == "" {
+		reason = "cancelled"
+	}
+	return newActionResult(req, TypeActionCancelled, reason)
+}
+
+// NewActionCancel builds an action.cancel request for actionID.
+func NewActionCancel(actionID, reason, requestedBy string) ActionCancel {
+	return ActionCancel{
+		Type:        TypeActionCancel,
+		Version:     SchemaVersion,
+		ActionID:    actionID,
+		Reason:      reason,
+		RequestedBy: requestedBy,
+		DedupKey:    actionID + ":cancel",
+		CreatedAt:   now(),
+	}
+}
+
+func newActionResult(req ActionRequested, typ, errText string) ActionResult {
+	suffix := "completed"
+	switch typ {
+	case TypeActionFailed:
+		suffix = "failed"
+	case TypeActionCancelled:
+		suffix = "cancelled"
+	}
+	return ActionResult{
+		Type:            typ,
+		Version:         SchemaVersion,
+		ActionID:        req.ActionID,
+		Kind:            req.Kind,
+		AlertFP:         req.AlertFP,
+		IncidentID:      req.IncidentID,
+		CorrelationID:   req.CorrelationID,
+		CausationID:     req.ActionID,
+		Rule:            req.Rule,
+		CompensationOf:  req.CompensationOf,
+		CompensationNo:  req.CompensationNo,
+		DesiredReplicas: req.DesiredReplicas,
+		TargetRunner:    req.TargetRunner,
+		Target:          req.Target,
+		Error:           errText,
+		DedupKey:        req.ActionID + ":" + suffix,
+		CreatedAt:       now(),
+	}
+}
+
+// ValidationError reports a payload that decoded but violates the schema.
+type ValidationError struct {
+	Type  string
+	Field string
+	Msg   string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s event: %s %s", e.Type, e.Field, e.Msg)
+}
+
+// ErrUnsupportedVersion is returned for payloads newer than SchemaVersion.
+var ErrUnsupportedVersion = errors.New("unsupported event schema version")
+
+func invalid(typ, field, msg string) error {
+	return &ValidationError{Type: typ, Field: field, Msg: msg}
+}
+
+func checkEnvelope(want []string, typ string, version int) error {
+	ok := false
+	for _, w := range want {
+		if typ == w {
+			ok = true
+		}
+	}
+	if !ok {
+		return invalid(want[0], "type", fmt.Sprintf("is %q", typ))
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("%w: %s v%d (max v%d)", ErrUnsupportedVersion, typ, version, SchemaVersion)
+	}
+	return nil
+}
+
+// Validate checks required fields of an alert.raised event.
+func (e AlertRaised) Validate() error {
+	if err := checkEnvelope([]string{TypeAlertRaised}, e.Type, e.Version); err != nil {
+		return err
+	}
+	if e.Fingerprint == "" {
+		return invalid(e.Type, "fingerprint", "is required")
+	}
+	if e.Status != StatusFiring && e.Status != StatusResolved {
+		return invalid(e.Type, "status", fmt.Sprintf("must be %s or %s, got %q", StatusFiring, StatusResolved, e.Status))
+	}
+	if e.DedupKey == "" {
+		return invalid(e.Type, "dedup_key", "is required")
+	}
+	return nil
+}
+
+// Validate checks required fields of an incident.opened event.
+func (e IncidentOpened) Validate() error {
+	if err := checkEnvelope([]string{TypeIncidentOpened}, e.Type, e.Version); err != nil {
+		return err
+	}
+	if e.IncidentID == "" {
+		return invalid(e.Type, "incident_id", "is required")
+	}
+	if e.AlertFP == "" {
+		return invalid(e.Type, "alert_fp", "is required")
+	}
+	if e.DedupKey == "" {
+		return invalid(e.Type, "dedup_key", "is required")
+	}
+	return nil
+}
+
+// Validate checks required fields of an incident.note event.
+func (e IncidentNote) Validate() error {
+	if err := checkEnvelope([]string{TypeIncidentNote}, e.Type, e.Version); err != nil {
+		return err
+	}
+	if e.IncidentID == "" && e.AlertFP == "" {
+		return invalid(e.Type, "incident_id", "or alert_fp is required")
+	}
+	if e.Note == "" {
+		return invalid(e.Type, "note", "is required")
+	}
+	if e.DedupKey == "" {
+		return invalid(e.Type, "dedup_key", "is required")
+	}
+	return nil
+}
+
+// Validate checks required fields of an action.requested event, including
+// the parameters required by known action kinds.
+func (e ActionRequested) Validate() error {
+	if err := checkEnvelope([]string{TypeActionRequested}, e.Type, e.Version); err != nil {
+		return err
+	}
+	if e.ActionID == "" {
+		return invalid(e.Type, "action_id", "is required")
+	}
+	if e.Kind == "" {
+		return invalid(e.Type, "kind", "is required")
+	}
+	switch e.Kind {
+	case KindScaleDocker:
+		if e.DesiredReplicas < 1 {
+			return invalid(e.Type, "desired_replicas", fmt.Sprintf("must be >= 1 for %s, got %d", e.Kind, e.DesiredReplicas))
+		}
+		if e.Target != nil {
+			if err := e.Target.Validate(e.Type); err != nil {
+				return err
+			}
+		}
+	case KindRestartRunner:
+		if e.TargetRunner == "" {
+			return invalid(e.Type, "target_runner", "is required for "+e.Kind)
+		}
+	}
+	if e.TimeoutSeconds < 0 {
+		return invalid(e.Type, "timeout_seconds", fmt.Sprintf("must be >= 0, got %d", e.TimeoutSeconds))
+	}
+	if e.DedupKey == "" {
+		return invalid(e.Type, "dedup_key", "is required")
+	}
+	return nil
+}
+
+// Validate checks required fields of an action.cancel event.
+func (e ActionCancel) Validate() error {
+	if err := checkEnvelope([]string{TypeActionCancel}, e.Type, e.Version); err != nil {
+		return err
+	}
+	if e.ActionID == "" {
+		return invalid(e.Type, "action_id", "is required")
+	}
+	if e.DedupKey == "" {
+		return invalid(e.Type, "dedup_key", "is required")
+	}
+	return nil
+}
+
+// Validate checks required fields of an action result event.
+func (e ActionResult) Validate() error {
+	if err := checkEnvelope([]string{TypeActionCompleted, TypeActionFailed, TypeActionRetrying, TypeActionCancelled}, e.Type, e.Version); err != nil {
+		return err
+	}
+	if e.ActionID == "" {
+		return invalid(e.Type, "action_id", "is required")
+	}
+	if (e.Type == TypeActionFailed || e.Type == TypeActionRetrying) && e.Error == "" {
+		return invalid(e.Type, "error", "is required")
+	}
+	if e.Type == TypeActionRetrying && e.Attempt < 1 {
+		return invalid(e.Type, "attempt", "must be >= 1")
+	}
+	if e.DedupKey == "" {
+		return invalid(e.Type, "dedup_key", "is required")
+	}
+	return nil
+}
+
+// Peek decodes only the envelope of a payload.
+func Peek(data []byte) (Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return env, fmt.Errorf("decode event envelope: %w", err)
+	}
+	if env.Type == "" {
+		return env, invalid("unknown", "type", "is required")
+	}
+	if env.Version == 0 {
+		env.Version = 1
+	}
+	return env, nil
+}
+
+func decode(data []byte, v any, typ string) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("decode %s: %w", typ, err)
+	}
+	return nil
+}
+
+// DecodeAlertRaised decodes and validates an alert.raised payload.
+func DecodeAlertRaised(data []byte) (AlertRaised, error) {
+	var e AlertRaised
+	if err := decode(data, &e, TypeAlertRaised); err != nil {
+		return e, err
+	}
+	if e.Version == 0 {
+		e.Version = 1
+	}
+	return e, e.Validate()
+}
+
+// DecodeIncidentOpened decodes and validates an incident.opened payload.
+func DecodeIncidentOpened(data []byte) (IncidentOpened, error) {
+	var e IncidentOpened
+	if err := decode(data, &e, TypeIncidentOpened); err != nil {
+		return e, err
+	}
+	if e.Version == 0 {
+		e.Version = 1
+	}
+	return e, e.Validate()
+}
+
+// DecodeIncidentNote decodes and validates an incident.note payload.
+func DecodeIncidentNote(data []byte) (IncidentNote, error) {
+	var e IncidentNote
+	if err := decode(data, &e, TypeIncidentNote); err != nil {
+		return e, err
+	}
+	if e.Version == 0 {
+		e.Version = 1
+	}
+	return e, e.Validate()
+}
+
+// DecodeActionRequested decodes and validates an action.requested payload.
+func DecodeActionRequested(data []byte) (ActionRequested, error) {
+	var e ActionRequested
+	if err := decode(data, &e, TypeActionRequested); err != nil {
+		return e, err
+	}
+	if e.Version == 0 {
+		e.Version = 1
+	}
+	if e.DedupKey == "" {
+		// v1 payloads without dedup_key were keyed by action id
+		e.DedupKey = e.ActionID
+	}
+	return e, e.Validate()
+}
+
+// DecodeActionResult decodes and validates an action.completed, action.failed,
+// action.retrying or action.cancelled payload.
+func DecodeActionResult(data []byte) (ActionResult, error) {
+	var e ActionResult
+	if err := decode(data, &e, "action result"); err != nil {
+		return e, err
+	}
+	if e.Version == 0 {
+		e.Version = 1
+	}
+	return e, e.Validate()
+}
+
+// DecodeActionCancel decodes and validates an action.cancel payload.
+func DecodeActionCancel(data []byte) (ActionCancel, error) {
+	var e ActionCancel
+	if err := decode(data, &e, TypeActionCancel); err != nil {
+		return e, err
+	}
+	if e.Version == 0 {
+		e.Version = 1
+	}
+	return e, e.Validate()
+}
@@ -0,0 +1,194 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// checkErr fails t unless err matches want: nil for "", otherwise an error
+// containing want.
+func checkErr(t *testing.T, err error, want string) {
+	t.Helper()
+	if want == "" {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return
+	}
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("err = %v, want %q", err, want)
+	}
+}
+
+func TestPeek(t *testing.T) {
+	tests := []struct {
+		name        string
+		in          string
+		wantType    string
+		wantVersion int
+		wantErr     string
+	}{
+		{"versioned", `{"type":"alert.raised","version":1}`, TypeAlertRaised, 1, ""},
+		{"unversioned is v1", `{"type":"action.requested"}`, TypeActionRequested, 1, ""},
+		{"no type", `{"version":1}`, "", 0, "type is required"},
+		{"not json", `not json`, "", 0, "decode event envelope"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := Peek([]byte(tt.in))
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr == "" && (env.Type != tt.wantType || env.Version != tt.wantVersion) {
+				t.Errorf("Peek = %+v, want type %q version %d", env, tt.wantType, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestDecodeAlertRaised(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		wantErr string
+	}{
+		{"valid", `{"type":"alert.raised","version":1,"fingerprint":"fp","status":"firing","dedup_key":"k"}`, ""},
+		{"unversioned", `{"type":"alert.raised","fingerprint":"fp","status":"resolved","dedup_key":"k"}`, ""},
+		{"wrong type", `{"type":"incident.opened","fingerprint":"fp","status":"firing","dedup_key":"k"}`, `type is "incident.opened"`},
+		{"newer version", `{"type":"alert.raised","version":2,"fingerprint":"fp","status":"firing","dedup_key":"k"}`, "unsupported event schema version"},
+		{"no fingerprint", `{"type":"alert.raised","status":"firing","dedup_key":"k"}`, "fingerprint is required"},
+		{"bad status", `{"type":"alert.raised","fingerprint":"fp","status":"pending","dedup_key":"k"}`, "status must be firing or resolved"},
+		{"no dedup key", `{"type":"alert.raised","fingerprint":"fp","status":"firing"}`, "dedup_key is required"},
+		{"malformed", `{"type":`, "decode alert.raised"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := DecodeAlertRaised([]byte(tt.in))
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr == "" && e.Version != 1 {
+				t.Errorf("version = %d, want 1", e.Version)
+			}
+		})
+	}
+}
+
+func TestNewerVersionIsUnsupported(t *testing.T) {
+	_, err := DecodeActionCancel([]byte(`{"type":"action.cancel","version":9,"action_id":"a","dedup_key":"k"}`))
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("err = %v, want ErrUnsupportedVersion", err)
+	}
+	var ve *ValidationError
+	if errors.As(err, &ve) {
+		t.Fatalf("a newer version is not a validation error: %v", err)
+	}
+}
+
+func TestDecodeIncidentEvents(t *testing.T) {
+	tests := []struct {
+		name    string
+		decode  func([]byte) error
+		in      string
+		wantErr string
+	}{
+		{"opened", decodeOpened, `{"type":"incident.opened","incident_id":"inc","alert_fp":"fp","dedup_key":"k"}`, ""},
+		{"opened without id", decodeOpened, `{"type":"incident.opened","alert_fp":"fp","dedup_key":"k"}`, "incident_id is required"},
+		{"opened without fp", decodeOpened, `{"type":"incident.opened","incident_id":"inc","dedup_key":"k"}`, "alert_fp is required"},
+		{"note by fingerprint", decodeNote, `{"type":"incident.note","alert_fp":"fp","note":"n","dedup_key":"k"}`, ""},
+		{"note without incident", decodeNote, `{"type":"incident.note","note":"n","dedup_key":"k"}`, "incident_id or alert_fp is required"},
+		{"note without text", decodeNote, `{"type":"incident.note","incident_id":"inc","dedup_key":"k"}`, "note is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkErr(t, tt.decode([]byte(tt.in)), tt.wantErr)
+		})
+	}
+}
+
+func decodeOpened(b []byte) error { _, err := DecodeIncidentOpened(b); return err }
+func decodeNote(b []byte) error   { _, err := DecodeIncidentNote(b); return err }
+
+func TestDecodeActionRequested(t *testing.T) {
+	tests := []struct {
+		name      string
+		in        string
+		wantErr   string
+		wantDedup string
+	}{
+		{"scale", `{"type":"action.requested","action_id":"a","kind":"scale_docker","desired_replicas":2,"dedup_key":"k"}`, "", "k"},
+		{"v1 without dedup key uses action id", `{"type":"action.requested","action_id":"a","kind":"scale_docker","desired_replicas":2}`, "", "a"},
+		{"no action id", `{"type":"action.requested","kind":"scale_docker","desired_replicas":2,"dedup_key":"k"}`, "action_id is required", ""},
+		{"no kind", `{"type":"action.requested","action_id":"a","dedup_key":"k"}`, "kind is required", ""},
+		{"scale without replicas", `{"type":"action.requested","action_id":"a","kind":"scale_docker"}`, "desired_replicas must be >= 1", ""},
+		{"scale with empty target", `{"type":"action.requested","action_id":"a","kind":"scale_docker","desired_replicas":1,"target":{}}`, "target needs service or labels", ""},
+		{"scale with inverted bounds", `{"type":"action.requested","action_id":"a","kind":"scale_docker","desired_replicas":1,"target":{"service":"app","min_replicas":3,"max_replicas":2}}`, "min_replicas 3 > max_replicas 2", ""},
+		{"restart without runner", `{"type":"action.requested","action_id":"a","kind":"restart_runner"}`, "target_runner is required", ""},
+		{"negative timeout", `{"type":"action.requested","action_id":"a","kind":"restart_runner","target_runner":"r","timeout_seconds":-1}`, "timeout_seconds must be >= 0", ""},
+		{"unknown kind is accepted", `{"type":"action.requested","action_id":"a","kind":"custom"}`, "", "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := DecodeActionRequested([]byte(tt.in))
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr == "" && e.DedupKey != tt.wantDedup {
+				t.Errorf("dedup_key = %q, want %q", e.DedupKey, tt.wantDedup)
+			}
+		})
+	}
+}
+
+func TestDecodeActionResult(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		wantErr string
+	}{
+		{"completed", `{"type":"action.completed","action_id":"a","dedup_key":"k"}`, ""},
+		{"cancelled", `{"type":"action.cancelled","action_id":"a","dedup_key":"k"}`, ""},
+		{"failed without error", `{"type":"action.failed","action_id":"a","dedup_key":"k"}`, "error is required"},
+		{"retrying without attempt", `{"type":"action.retrying","action_id":"a","error":"e","dedup_key":"k"}`, "attempt must be >= 1"},
+		{"retrying", `{"type":"action.retrying","action_id":"a","error":"e","attempt":1,"dedup_key":"k"}`, ""},
+		{"request is not a result", `{"type":"action.requested","action_id":"a","dedup_key":"k"}`, `type is "action.requested"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeActionResult([]byte(tt.in))
+			checkErr(t, err, tt.wantErr)
+		})
+	}
+}
+
+// Events built by the constructors validate and survive a round trip.
+func TestConstructorsRoundTrip(t *testing.T) {
+	req := NewActionRequested("fp", KindScaleDocker)
+	req.DesiredReplicas = 2
+	req.IncidentID = "inc"
+	tests := []struct {
+		name   string
+		v      any
+		decode func([]byte) error
+	}{
+		{"alert.raised", NewAlertRaised("fp", StatusFiring, "ep"), func(b []byte) error { _, err := DecodeAlertRaised(b); return err }},
+		{"incident.opened", NewIncidentOpened("fp"), decodeOpened},
+		{"incident.note", NewIncidentNote("inc", "fp", "test", "note"), decodeNote},
+		{"action.requested", req, func(b []byte) error { _, err := DecodeActionRequested(b); return err }},
+		{"action.completed", NewActionCompleted(req), func(b []byte) error { _, err := DecodeActionResult(b); return err }},
+		{"action.failed", NewActionFailed(req, errors.New("boom")), func(b []byte) error { _, err := DecodeActionResult(b); return err }},
+		{"action.cancelled", NewActionCancelled(req, "by operator"), func(b []byte) error { _, err := DecodeActionResult(b); return err }},
+		{"action.cancel", NewActionCancel("a", "r", "me"), func(b []byte) error { _, err := DecodeActionCancel(b); return err }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(tt.v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.decode(b); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+		})
+	}
+	res := NewActionCompleted(req)
+	if res.IncidentID != "inc" || res.CausationID != req.ActionID || res.DedupKey != req.ActionID+":completed" {
+		t.Errorf("completed result does not echo the request: %+v", res)
+	}
+}
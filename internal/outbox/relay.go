@@ -0,0 +1,232 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// Relay publishes unsent rows of outbox_events to Kafka in insertion order.
+// A row is marked with published_at only after the broker acknowledged it;
+// failed rows keep their position, get attempts/last_error updated and are
+// retried with exponential backoff.
+type Relay struct {
+	db         *sql.DB
+	writers    map[string]*kafka.Writer
+	batchSize  int
+	interval   time.Duration
+	maxBackoff time.Duration
+	wake       chan struct{}
+
+	mu              sync.Mutex
+	failures        int
+	lastError       string
+	lastPublishedAt string
+}
+
+// Stats describes the current outbox backlog and relay state.
+type Stats struct {
+	Pending             int    `json:"pending"`
+	OldestPendingAt     string `json:"oldest_pending_at,omitempty"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	LastError           string `json:"last_error,omitempty"`
+	LastPublishedAt     string `json:"last_published_at,omitempty"`
+}
+
+// NewRelay creates a relay that routes outbox rows to writers by event type.
+func NewRelay(db *sql.DB, writers map[string]*kafka.Writer) *Relay {
+	return &Relay{
+		db:         db,
+		writers:    writers,
+		batchSize:  100,
+		interval:   time.Second,
+		maxBackoff: 30 * time.Second,
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// SetBatchSize limits how many rows are published per round.
+func (r *Relay) SetBatchSize(n int) {
+	if n > 0 {
+		r.batchSize = n
+	}
+}
+
+// SetInterval sets the poll interval used when the relay is idle.
+func (r *Relay) SetInterval(d time.Duration) {
+	if d > 0 {
+		r.interval = d
+	}
+}
+
+// SetMaxBackoff caps the delay between retries after publish failures.
+func (r *Relay) SetMaxBackoff(d time.Duration) {
+	if d > 0 {
+		r.maxBackoff = d
+	}
+}
+
+// Notify wakes the relay so freshly committed rows are published without
+// waiting for the next poll.
+func (r *Relay) Notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run publishes the backlog until ctx is cancelled.
+func (r *Relay) Run(ctx context.Context) {
+	for {
+		n, err := r.publishBatch(ctx)
+		wait := r.interval
+		r.mu.Lock()
+		if err != nil {
+			r.failures++
+			r.lastError = err.Error()
+			wait = r.backoff(r.failures)
+			log.Printf("outbox relay: publish failed (attempt %d, retry in %s): %v", r.failures, wait, err)
+		} else {
+			r.failures = 0
+			if n == r.batchSize {
+				// More rows are likely waiting; continue right away.
+				wait = 0
+			}
+		}
+		failing := r.failures > 0
+		r.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		wake := r.wake
+		if failing {
+			// Do not let new rows bypass the backoff.
+			wake = nil
+		}
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+func (r *Relay) backoff(failures int) time.Duration {
+	d := r.interval
+	for i := 1; i < failures && d < r.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > r.maxBackoff {
+		d = r.maxBackoff
+	}
+	return d
+}
+
+type row struct {
+	id      int64
+	typ     string
+	payload string
+}
+
+// publishBatch sends up to batchSize unsent rows and returns how many were
+// published. Rows are locked for the duration of the round so that two
+// relays on the same database never interleave.
+func (r *Relay) publishBatch(ctx context.Context) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, type, payload FROM outbox_events WHERE published_at IS NULL ORDER BY id LIMIT $1 FOR UPDATE`, r.batchSize)
+	if err != nil {
+		return 0, err
+	}
+	var batch []row
+	for rows.Next() {
+		var rw row
+		if err := rows.Scan(&rw.id, &rw.typ, &rw.payload); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, rw)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(batch) == 0 {
+		return 0, nil
+	}
+
+	published := 0
+	var pubErr error
+	// Publish consecutive rows of the same type together; stop at the first
+	// failure so later rows never overtake an unsent one.
+	for start := 0; start < len(batch); {
+		end := start + 1
+		for end < len(batch) && batch[end].typ == batch[start].typ {
+			end++
+		}
+		run := batch[start:end]
+		w, ok := r.writers[run[0].typ]
+		if !ok {
+			pubErr = fmt.Errorf("no writer for event type %q", run[0].typ)
+		} else {
+			msgs := make([]kafka.Message, len(run))
+			for i, rw := range run {
+				msgs[i] = kafka.Message{Value: []byte(rw.payload)}
+			}
+			pctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			pubErr = w.WriteMessages(pctx, msgs...)
+			cancel()
+		}
+		now := time.Now().UTC().Format(time.RFC3339)
+		if pubErr != nil {
+			for _, rw := range run {
+				if _, err := tx.ExecContext(ctx, `UPDATE outbox_events SET attempts=attempts+1, last_error=$1 WHERE id=$2`, pubErr.Error(), rw.id); err != nil {
+					return published, err
+				}
+			}
+			break
+		}
+		for _, rw := range run {
+			if _, err := tx.ExecContext(ctx, `UPDATE outbox_events SET published_at=$1, attempts=attempts+1, last_error=NULL WHERE id=$2`, now, rw.id); err != nil {
+				return published, err
+			}
+		}
+		published += len(run)
+		r.mu.Lock()
+		r.lastPublishedAt = now
+		r.mu.Unlock()
+		start = end
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return published, pubErr
+}
+
+// Stats reports the number of unsent rows together with the relay state.
+func (r *Relay) Stats(ctx context.Context) (Stats, error) {
+	var st Stats
+	var oldest sql.NullString
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*), MIN(created_at) FROM outbox_events WHERE published_at IS NULL`).Scan(&st.Pending, &oldest)
+	if err != nil {
+		return st, err
+	}
+	st.OldestPendingAt = oldest.String
+	r.mu.Lock()
+	st.ConsecutiveFailures = r.failures
+	st.LastError = r.lastError
+	st.LastPublishedAt = r.lastPublishedAt
+	r.mu.Unlock()
+	return st, nil
+}
@@ -0,0 +1,43 @@
+package outbox
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRelayBackoff(t *testing.T) {
+	tests := []struct {
+		name       string
+		interval   time.Duration
+		maxBackoff time.Duration
+		failures   int
+		want       time.Duration
+	}{
+		{"first failure waits one interval", time.Second, 30 * time.Second, 1, time.Second},
+		{"doubles per failure", time.Second, 30 * time.Second, 2, 2 * time.Second},
+		{"doubles again", time.Second, 30 * time.Second, 4, 8 * time.Second},
+		{"capped at max backoff", time.Second, 30 * time.Second, 6, 30 * time.Second},
+		{"stays capped", time.Second, 30 * time.Second, 1000, 30 * time.Second},
+		{"interval above max backoff", time.Minute, 30 * time.Second, 1, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRelay(nil, nil)
+			r.SetInterval(tt.interval)
+			r.SetMaxBackoff(tt.maxBackoff)
+			if got := r.backoff(tt.failures); got != tt.want {
+				t.Errorf("backoff(%d) = %s, want %s", tt.failures, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRelaySettersIgnoreNonPositive(t *testing.T) {
+	r := NewRelay(nil, nil)
+	r.SetBatchSize(0)
+	r.SetInterval(-time.Second)
+	r.SetMaxBackoff(0)
+	if r.batchSize != 100 || r.interval != time.Second || r.maxBackoff != 30*time.Second {
+		t.Errorf("defaults changed: batch=%d interval=%s max=%s", r.batchSize, r.interval, r.maxBackoff)
+	}
+}
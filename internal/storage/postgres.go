@@ -8,6 +8,14 @@ import (
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
+// Querier is satisfied by both *sql.DB and *sql.Tx, so helpers can run inside
+// or outside a transaction.
+type Querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
 // Open opens a PostgreSQL database using pgx stdlib driver.
 func Open(dsn string) (*sql.DB, error) {
 	db, err := sql.Open("pgx", dsn)
@@ -38,6 +46,7 @@ func Migrate(db *sql.DB) error {
 			last_seen TEXT,
 			occurrences INTEGER DEFAULT 1
 		)`,
+		`ALTER TABLE alerts ADD COLUMN IF NOT EXISTS episode_id TEXT`,
 		`CREATE INDEX IF NOT EXISTS idx_alerts_fp ON alerts(fingerprint)`,
 		`CREATE TABLE IF NOT EXISTS outbox_events (
 			id SERIAL PRIMARY KEY,
@@ -56,5 +65,42 @@ func Migrate(db *sql.DB) error {
 			return err
 		}
 	}
+	return MigrateOutbox(db)
+}
+
+// PruneInbox deletes inbox dedup keys older than ttl and returns how many were removed.
+func PruneInbox(ctx context.Context, db *sql.DB, ttl time.Duration) (int64, error) {
+	cutoff := time.Now().UTC().Add(-ttl).Format(time.RFC3339)
+	res, err := db.ExecContext(ctx, `DELETE FROM inbox WHERE created_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// MigrateOutbox adds the delivery-tracking columns used by the outbox relay
+// to an existing outbox_events table. Rows written before the relay existed
+// were already published directly, so they are marked as published.
+func MigrateOutbox(db *sql.DB) error {
+	var tracked bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'outbox_events' AND column_name = 'published_at')`).Scan(&tracked)
+	if err != nil {
+		return err
+	}
+	stmts := []string{
+		`ALTER TABLE outbox_events ADD COLUMN IF NOT EXISTS published_at TEXT`,
+		`ALTER TABLE outbox_events ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE outbox_events ADD COLUMN IF NOT EXISTS last_error TEXT`,
+		`CREATE INDEX IF NOT EXISTS idx_outbox_unpublished ON outbox_events(id) WHERE published_at IS NULL`,
+	}
+	if !tracked {
+		stmts = append(stmts, `UPDATE outbox_events SET published_at = created_at WHERE published_at IS NULL`)
+	}
+	for _, s := range stmts {
+		if _, err := db.Exec(s); err != nil {
+			return err
+		}
+	}
 	return nil
 }